@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// access log settings, the log is written as JSON lines to AccessLog, "-" for stdout.
+var (
+	AccessLog        string
+	AccessLogMaxSize int64 // megabytes, the file is rotated once it's reached
+	AccessLogBackups int   // rotated files kept, as AccessLog.1, AccessLog.2...
+)
+
+var accessLog io.Writer
+
+type accessEntry struct {
+	Time        time.Time `json:"time"`
+	Host        string    `json:"host"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	Duration    float64   `json:"duration_ms"`
+	ClientIP    string    `json:"client_ip"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Cold        bool      `json:"cold"`
+	WakeWait    float64   `json:"wake_wait_ms,omitempty"`
+}
+
+// accessLogWriter records the status and size of the response, it keeps the
+// Hijacker of the wrapped writer so tunnels still work.
+type accessLogWriter struct {
+	http.ResponseWriter
+	entry accessEntry
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.entry.Status == 0 {
+		w.entry.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.entry.Status == 0 {
+		w.entry.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.entry.Bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection can't be hijacked")
+	}
+	if w.entry.Status == 0 {
+		// the backend's response goes straight to the client
+		w.entry.Status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logEntry returns the access log entry of the request for the proxy to
+// annotate, nil if there's no access log.
+func logEntry(w http.ResponseWriter) *accessEntry {
+	if lw, ok := w.(*accessLogWriter); ok {
+		return &lw.entry
+	}
+	return nil
+}
+
+// logRequests writes an access log line for every request once it's served.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lw := &accessLogWriter{ResponseWriter: w, entry: accessEntry{
+			Time:     start,
+			Host:     r.Host,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			ClientIP: r.RemoteAddr,
+		}}
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			lw.entry.ClientIP = ip
+		}
+
+		h.ServeHTTP(lw, r)
+
+		if lw.entry.Status == 0 {
+			lw.entry.Status = http.StatusOK
+		}
+		lw.entry.Duration = milliseconds(time.Now().Sub(start))
+		b, err := json.Marshal(lw.entry)
+		if err != nil {
+			log.Errorln(err)
+			return
+		}
+		if _, err := accessLog.Write(append(b, '\n')); err != nil {
+			log.Errorln("Error writing the access log: ", err)
+		}
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func openAccessLog(path string) (io.Writer, error) {
+	if path == "-" {
+		return &lockedWriter{w: os.Stdout}, nil
+	}
+	f := &rotatingFile{path: path, maxSize: AccessLogMaxSize << 20, backups: AccessLogBackups}
+	return f, f.open()
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(b)
+}
+
+// rotatingFile is a file renamed to path.1 once it reaches maxSize, older
+// files are shifted up to path.<backups> and the oldest one is removed.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		// a previous rotation couldn't reopen it
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			log.Errorf("unable to rotate the access log %s: %s", f.path, err)
+			if f.file == nil {
+				return 0, err
+			}
+			// keep writing to the current file, the next write tries again
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file out of the way and opens a new one. Whatever fails,
+// the file at path is open afterwards unless it can't be opened at all, in
+// which case f.file is nil.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.shift()
+	}
+	if oerr := f.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+// shift renames the backups one up, dropping the oldest, and the file to the first backup.
+func (f *rotatingFile) shift() error {
+	if f.backups == 0 {
+		return os.Remove(f.path)
+	}
+	if err := os.Remove(fmt.Sprintf("%s.%d", f.path, f.backups)); err != nil && !os.IsNotExist(err) {
+		log.Warningf("access log rotation: %s", err)
+	}
+	for i := f.backups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			log.Warningf("access log rotation: %s", err)
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AdminAddr is where the admin API listens, a unix socket or a tcp address,
+// e.g. unix:///var/run/autosleep.sock or tcp://127.0.0.1:8081. Empty disables it.
+var AdminAddr string
+
+const DefaultAdminAddr = "unix:///var/run/autosleep.sock"
+
+// hostStatus describes a managed host in the admin API.
+type hostStatus struct {
+	Host         string     `json:"host"`
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	State        string     `json:"state"`      // running, unready, paused, stopped or waking
+	SleepMode    string     `json:"sleep_mode"` // stop or pause
+	LastAccess   time.Time  `json:"last_access"`
+	StartedAt    time.Time  `json:"started_at"`
+	IdleDeadline *time.Time `json:"idle_deadline,omitempty"` // missing if it never sleeps
+	SleepReason  string     `json:"sleep_reason,omitempty"`  // idle or admin, while it's asleep
+	Error        string     `json:"error,omitempty"`
+}
+
+func newHostStatus(host string, c *ContainerInfo) hostStatus {
+	status := hostStatus{
+		Host:      host,
+		ID:        c.ID,
+		Name:      containerName(c),
+		State:     c.state(),
+		SleepMode: c.SleepMode,
+	}
+
+	c.mu.Lock()
+	status.LastAccess = c.LastAccess
+	status.StartedAt = c.StartedAt
+	if !(c.Running && !c.Paused && c.Ready) {
+		status.SleepReason = c.SleepReason
+	}
+	wakeError := c.WakeError
+	c.mu.Unlock()
+
+	if c.AutoSleep {
+		deadline := status.LastAccess.Add(c.idleTimeout())
+		status.IdleDeadline = &deadline
+	}
+	if c.DependsError != nil {
+		status.Error = c.DependsError.Error()
+	} else if wakeError != nil && status.State != "running" {
+		status.Error = wakeError.Error()
+	}
+	return status
+}
+
+func (c *ContainerInfo) state() string {
+	if c.groupWaking() {
+		return "waking"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.Running && c.Paused:
+		return "paused"
+	case c.Running && !c.Ready:
+		// its readiness probe failed, the next request probes it again
+		return "unready"
+	case c.Running:
+		return "running"
+	}
+	return "stopped"
+}
+
+// serveAdmin serves the admin API:
+//
+//	GET  /hosts               lists the managed hosts
+//	GET  /hosts/<host>        shows a host
+//	POST /hosts/<host>/wake   wakes up the host's containers, waiting until they're ready
+//	POST /hosts/<host>/sleep  puts the host's containers to sleep
+//	POST /hosts/<host>/touch  resets the host's idle timer
+//	POST /discover            rediscovers the containers
+//	GET  /metrics             Prometheus metrics
+func serveAdmin(addr string) error {
+	proto, laddr, err := parseHost(addr)
+	if err != nil {
+		return err
+	}
+	if proto != "unix" && proto != "tcp" {
+		return fmt.Errorf("invalid admin address %s, expected unix:// or tcp://", addr)
+	}
+	if proto == "unix" {
+		if err := removeStaleSocket(laddr); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen(proto, laddr)
+	if err != nil {
+		return err
+	}
+	if proto == "unix" {
+		if err := os.Chmod(laddr, 0660); err != nil {
+			log.Warningf("admin socket %s: %s", laddr, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hosts", adminListHosts)
+	mux.HandleFunc("/hosts/", adminHost)
+	mux.HandleFunc("/discover", adminDiscover)
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	log.Println("admin API listening on", addr)
+	return http.Serve(l, mux)
+}
+
+// removeStaleSocket removes the socket left over at path by a previous run. A
+// socket still accepting connections belongs to a running autosleep and
+// anything else than a socket isn't ours to remove, both are errors.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use, is autosleep already running?", path)
+	}
+	return os.Remove(path)
+}
+
+func adminListHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, listHosts())
+}
+
+// listHosts lists the HTTP hosts, and the containers only reached over TCP
+// under their container name.
+func listHosts() []hostStatus {
+	containersMu.RLock()
+	hosts := []hostStatus{}
+	for host, c := range hostContainerInfo {
+		hosts = append(hosts, newHostStatus(host, c))
+	}
+	for _, c := range idContainerInfo {
+		if len(c.Hosts) == 0 && len(c.TCPRoutes) > 0 {
+			hosts = append(hosts, newHostStatus(containerName(c), c))
+		}
+	}
+	containersMu.RUnlock()
+
+	sort.Sort(byHost(hosts))
+	return hosts
+}
+
+func adminHost(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/hosts/")
+	action := ""
+	if i := strings.Index(host, "/"); i >= 0 {
+		host, action = host[:i], host[i+1:]
+	}
+
+	c := lookupAdminHost(host)
+	if c == nil {
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown host %s", host))
+		return
+	}
+
+	if action == "" {
+		if r.Method != "GET" {
+			writeAdminError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, newHostStatus(host, c))
+		return
+	}
+	if r.Method != "POST" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	switch action {
+	case "wake":
+		// keep it up for a full idle timeout
+		c.touch(time.Now())
+		if err := wakeContainer(c); err != nil {
+			writeAdminError(w, http.StatusServiceUnavailable, fmt.Sprintf("unable to wake up %s: %s", host, err))
+			return
+		}
+		log.Println("woken up through the admin API: ", c.ID[:12], c.Name)
+	case "sleep":
+		if waking(c) {
+			writeAdminError(w, http.StatusConflict, fmt.Sprintf("%s is waking up", host))
+			return
+		}
+		putToSleep(sleepUnit{key: c.unitKey(), members: c.members()}, time.Now().Sub(c.lastAccess()), SleepReasonAdmin)
+		log.Println("put to sleep through the admin API: ", c.ID[:12], c.Name)
+	case "touch":
+		c.touch(time.Now())
+	default:
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s", action))
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, newHostStatus(host, c))
+}
+
+// lookupAdminHost finds the container serving host, or else the container
+// named host, e.g. one only reached over TCP.
+func lookupAdminHost(host string) *ContainerInfo {
+	if c := lookupHost(host); c != nil {
+		return c
+	}
+
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+	for _, c := range idContainerInfo {
+		if containerName(c) == host {
+			return c
+		}
+	}
+	return nil
+}
+
+func adminDiscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	getAllDockerContainers()
+	updateTCPListeners()
+	log.Println("rediscovered the containers through the admin API")
+	writeAdminJSON(w, http.StatusOK, listHosts())
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, map[string]string{"error": msg})
+}
+
+type byHost []hostStatus
+
+func (h byHost) Len() int           { return len(h) }
+func (h byHost) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h byHost) Less(i, j int) bool { return h[i].Host < h[j].Host }
@@ -3,9 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,197 +19,498 @@ import (
 )
 
 var (
-	client            *docker.Client
 	wg                sync.WaitGroup
-	hostContainerInfo map[string]*ContainerInfo // maps hostname to ContainerInfo
+	hostContainerInfo map[string]*ContainerInfo // maps lower case hostname or wildcard to ContainerInfo
 	idContainerInfo   map[string]*ContainerInfo // maps ID to ContainerInfo
-	AutoSleepIn       int
-)
+	containersMu      sync.RWMutex              // guards the maps above, rebuilt by getAllDockerContainers
 
-const (
-	StopTimeout        = 5
-	StartContainerWait = 5
-	ReadWriteTimeout   = 10
+	ReadWriteTimeout time.Duration
+	HTTPAddr         string
 )
 
 type ContainerInfo struct {
 	ID          string
 	Name        string
+	Hosts       []string
+	Group       string
+	DependsOn   []string // container names
 	PortBinding map[docker.Port][]docker.PortBinding
 	Running     bool
+	Paused      bool
+	Ready       bool // passed its readiness probe since it was last started
 	LastAccess  time.Time
 	StartedAt   time.Time
+	IdleTimeout time.Duration // 0 means -autosleepin
+	AutoSleep   bool
+	SleepMode   string // SleepModeStop or SleepModePause
+	Schedules   []*Schedule
+	Busy        *BusyThresholds
+	Ignore      *TrafficRules
+	QueueDepth  int           // 0 means -queue-depth
+	QueueWait   time.Duration // 0 means QueueWait
+	WindowWake  time.Time     // start of the awake window it was last woken up for
+	PrewarmSlot int64         // start of the slot it was last pre-warmed for
+	PrewarmedAt time.Time     // pre-warmed and not accessed since
+	SleepReason string        // why it was put to sleep, empty once it's woken up
+	SleptAt     time.Time
+	Probe       *Probe
+	Direct      bool
+	Upstream    *url.URL // overrides -upstream
+	IP          string
+	Target      *url.URL // container's own address when Direct
+	WakingPage  *template.Template
+	WakeError   error // error of the last wake up, if it failed
+	OpenConns   int32 // upgraded and TCP connections, accessed atomically
+	TCPRoutes   []TCPRoute
+	TLSCert     string
+	TLSKey      string
+
+	Dependencies []*ContainerInfo
+	DependsError error            // missing dependency or cycle, found at discovery
+	WakeOrder    []*ContainerInfo // group and dependencies, dependencies first
+
+	queue wakeQueue
+
+	// guards Running, Paused, Ready, LastAccess, StartedAt, WindowWake,
+	// PrewarmSlot, PrewarmedAt, SleepReason, SleptAt, IP, Target and WakeError,
+	// written by wake ups, the events watcher and the sleep loop while requests
+	// read them
+	mu sync.Mutex
 }
 
 func main() {
-	flag.IntVar(&AutoSleepIn, "autosleepin", 60*30, "auto sleep containers in this many seconds")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: autosleep [options]\n       autosleep status|ls|wake|sleep ... (talks to a running autosleep)\n\noptions:\n")
+		flag.PrintDefaults()
+	}
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	applyConfig(cfg, true)
 
 	log.SetLevel(log.WarnLevel)
 
-	endpoint := "unix:///var/run/docker.sock"
-	client, _ = docker.NewClient(endpoint)
+	c, err := newDockerClient(DockerHost, DockerTLSVerify, DockerCertPath)
+	if err != nil {
+		log.Fatalf("unable to create a docker client for %s: %s", DockerHost, err)
+	}
+	setDockerClient(c)
+	if err := countDockerError(c.Ping()); err != nil {
+		log.Fatalf("unable to reach the docker daemon at %s: %s", DockerHost, err)
+	}
 
 	getAllDockerContainers()
+	if StateFile != "" {
+		if err := restoreState(StateFile); err != nil {
+			log.Errorf("unable to restore the state from %s: %s", StateFile, err)
+		}
+		go persistState(StateFile)
+	}
+	updateTCPListeners()
 
 	go watchDockerEvents()
+	go watchConfig()
+	go watchSchedules()
+	if Prewarm {
+		go watchPredictions()
+	}
+	if AdminAddr != "" {
+		go func() {
+			if err := serveAdmin(AdminAddr); err != nil {
+				log.Errorf("admin API on %s: %s", AdminAddr, err)
+			}
+		}()
+	}
+	if MetricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/metrics", serveMetrics)
+			log.Fatal(http.ListenAndServe(MetricsAddr, mux))
+		}()
+	}
 
 	go func() {
-		t := time.NewTicker(time.Second * time.Duration(AutoSleepIn/3))
 		for {
-			// instead of creating new Tickers for each container use only one Ticker,
+			// instead of creating new Tickers for each container use only one loop,
 			// good enuf for our purposes
 			// doesn't matter if the container lives for a bit longer
 			stopInactiveContainers()
-			<-t.C
+			time.Sleep(sleepCheckInterval())
 		}
 	}()
 
-	http.HandleFunc("/", proxy)
+	if AccessLog == "" {
+		http.HandleFunc("/", proxy)
+	} else {
+		if accessLog, err = openAccessLog(AccessLog); err != nil {
+			log.Fatalf("unable to open the access log: %s", err)
+		}
+		http.Handle("/", logRequests(http.HandlerFunc(proxy)))
+	}
+
+	// responses can't be written before the container is awake
+	writeTimeout := ReadWriteTimeout + QueueWait
 
 	s := &http.Server{
-		Addr:         ":80",
+		Addr:         HTTPAddr,
 		Handler:      nil,
-		ReadTimeout:  ReadWriteTimeout * time.Second,
-		WriteTimeout: ReadWriteTimeout * time.Second,
+		ReadTimeout:  ReadWriteTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
+	if HTTPSAddr != "" {
+		go func() {
+			tlsServer := &http.Server{
+				Addr:         HTTPSAddr,
+				Handler:      nil,
+				ReadTimeout:  ReadWriteTimeout,
+				WriteTimeout: writeTimeout,
+			}
+			log.Fatal(serveHTTPS(tlsServer))
+		}()
 	}
 	log.Fatal(s.ListenAndServe())
 }
 
+// getAllDockerContainers (re)discovers the containers. Containers known from
+// a previous discovery keep their ContainerInfo, along with their idle timer,
+// open connections and waiting requests.
 func getAllDockerContainers() {
-	imgs, _ := client.ListContainers(docker.ListContainersOptions{All: true})
+	imgs, err := dockerClient().ListContainers(docker.ListContainersOptions{All: true})
+	if countDockerError(err) != nil {
+		log.Errorln("Error listing containers: ", err)
+		return
+	}
+	containers := []*docker.Container{}
+	for _, img := range imgs {
+		if container, err := dockerClient().InspectContainer(img.ID); countDockerError(err) != nil {
+			log.Errorln(err)
+		} else {
+			containers = append(containers, container)
+		}
+	}
+
+	containersMu.Lock()
+	defer containersMu.Unlock()
+
+	known := idContainerInfo
 	hostContainerInfo = make(map[string]*ContainerInfo)
 	idContainerInfo = make(map[string]*ContainerInfo)
 
-	for _, img := range imgs {
-		container, _ := client.InspectContainer(img.ID)
-		vHost := splitKeyValueSlice(container.Config.Env)["VIRTUAL_HOST"]
-
-		if vHost != "" {
-			containerInfo := &ContainerInfo{
-				ID:          container.ID,
-				Name:        container.Name,
-				PortBinding: container.HostConfig.PortBindings,
-				Running:     container.State.Running,
-				LastAccess:  time.Now(),
-				StartedAt:   container.State.StartedAt}
+	candidates := []*ContainerInfo{}
+	grouped := []*ContainerInfo{}             // group members without an entry point of their own
+	all := make(map[string]*docker.Container) // maps name to container, to resolve dependencies
+	for _, container := range containers {
+		all[strings.TrimPrefix(container.Name, "/")] = container
+		hosts := parseVirtualHosts(splitKeyValueSlice(container.Config.Env)["VIRTUAL_HOST"])
 
+		tcpRoutes, err := parseTCPRoutes(container.Config.Labels)
+		if err != nil {
+			log.Warningf("container: %s %s, ignoring tcp listeners: %s", container.ID[:12], container.Name, err)
+		}
+
+		if len(hosts) == 0 && len(tcpRoutes) == 0 {
+			if containerGroup(container) != "" {
+				grouped = append(grouped, containerInfoFor(known, container))
+			}
+			continue
+		}
+
+		containerInfo := containerInfoFor(known, container)
+		containerInfo.Hosts = hosts
+		containerInfo.TCPRoutes = tcpRoutes
+		candidates = append(candidates, containerInfo)
+
+		for _, vHost := range hosts {
 			if existingContainer, ok := hostContainerInfo[vHost]; ok {
 				log.Warningf("container: %s has the same VIRTUAL_HOST=%s as container: %s", container.ID[:12], vHost, existingContainer.ID[:12])
 				if existingContainer.StartedAt.UnixNano() < container.State.StartedAt.UnixNano() {
 					log.Warningf("using the most recently used container: %s, with VIRTUAL_HOST=%s", containerInfo.ID[:12], vHost)
 					// only consider newer containers
 					hostContainerInfo[vHost] = containerInfo
-
-					// remove existing container from idContainerInfo
-					delete(idContainerInfo, existingContainer.ID)
-					idContainerInfo[container.ID] = containerInfo
 				} else {
 					log.Warningf("using the most recently used container: %s, with VIRTUAL_HOST=%s", existingContainer.ID[:12], vHost)
 				}
 			} else {
 				hostContainerInfo[vHost] = containerInfo
-				idContainerInfo[container.ID] = containerInfo
 			}
 		}
 	}
+
+	// only keep the containers still serving a host, or listening for tcp
+	for _, containerInfo := range hostContainerInfo {
+		idContainerInfo[containerInfo.ID] = containerInfo
+	}
+	for _, containerInfo := range candidates {
+		if len(containerInfo.TCPRoutes) > 0 {
+			idContainerInfo[containerInfo.ID] = containerInfo
+		}
+	}
+	addGroupMembers(grouped)
+	resolveDependencies(all, known)
+	indexGroups()
+
+	for _, containerInfo := range idContainerInfo {
+		log.Warnf("container: %s %s, group: %s, sleep mode: %s", containerInfo.ID[:12], containerInfo.Name, containerInfo.Group, containerInfo.SleepMode)
+	}
 }
 
-func watchDockerEvents() {
-	eventChan := make(chan *docker.APIEvents, 100)
-	defer close(eventChan)
+// containerInfoFor returns the refreshed ContainerInfo of a known container,
+// its settings read again in case the config changed. Unknown containers get a new one.
+func containerInfoFor(known map[string]*ContainerInfo, container *docker.Container) *ContainerInfo {
+	containerInfo, ok := known[container.ID]
+	if !ok {
+		return newContainerInfo(container)
+	}
+	isWaking := waking(containerInfo)
+	containerInfo.mu.Lock()
+	if !container.State.Running {
+		containerInfo.Ready = false
+	} else if !containerInfo.Running && !isWaking {
+		// started while autosleep wasn't watching
+		containerInfo.Ready = true
+	}
+	containerInfo.Running = container.State.Running
+	containerInfo.Paused = container.State.Paused
+	containerInfo.StartedAt = container.State.StartedAt
+	containerInfo.mu.Unlock()
+	containerInfo.PortBinding = container.HostConfig.PortBindings
+	containerInfo.Dependencies = nil
+	containerInfo.DependsError = nil
+	containerInfo.WakeOrder = nil
+	applySettings(containerInfo, container)
+	if container.State.Running {
+		updateTarget(containerInfo, container)
+	}
+	return containerInfo
+}
 
-	watching := false
-	for {
+// containerInfos returns the known containers, safe to use while they're rediscovered.
+func containerInfos() []*ContainerInfo {
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+	infos := make([]*ContainerInfo, 0, len(idContainerInfo))
+	for _, c := range idContainerInfo {
+		infos = append(infos, c)
+	}
+	return infos
+}
 
-		if client == nil {
-			break
-		}
-		err := client.Ping()
-		if err != nil {
-			log.Printf("Unable to ping docker daemon: %s", err)
-			if watching {
-				client.RemoveEventListener(eventChan)
-				watching = false
-				client = nil
-			}
-			time.Sleep(10 * time.Second)
-			break
+func containerByID(id string) *ContainerInfo {
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+	return idContainerInfo[id]
+}
 
-		}
+func newContainerInfo(container *docker.Container) *ContainerInfo {
+	containerInfo := &ContainerInfo{
+		ID:          container.ID,
+		Name:        container.Name,
+		PortBinding: container.HostConfig.PortBindings,
+		Running:     container.State.Running,
+		Paused:      container.State.Paused,
+		Ready:       container.State.Running, // started before autosleep, assumed ready
+		LastAccess:  time.Now(),
+		StartedAt:   container.State.StartedAt,
+		TLSCert:     container.Config.Labels[TLSCertLabel],
+		TLSKey:      container.Config.Labels[TLSKeyLabel]}
+
+	if containerInfo.TLSCert != "" && containerInfo.TLSKey == "" {
+		containerInfo.TLSKey = strings.TrimSuffix(containerInfo.TLSCert, ".crt") + ".key"
+	}
 
-		if !watching {
-			err = client.AddEventListener(eventChan)
-			if err != nil && err != docker.ErrListenerAlreadyExists {
-				log.Printf("Error registering docker event listener: %s", err)
-				time.Sleep(10 * time.Second)
-				continue
-			}
-			watching = true
-			log.Println("Watching docker events")
-		}
+	applySettings(containerInfo, container)
+	if containerInfo.Running {
+		updateTarget(containerInfo, container)
+	}
 
-		select {
+	if probe, err := parseProbe(container.Config.Labels); err != nil {
+		log.Warningf("container: %s %s, ignoring readiness probe: %s", container.ID[:12], container.Name, err)
+	} else {
+		containerInfo.Probe = probe
+	}
+	if page, err := parseWakingPage(container.Config.Labels); err != nil {
+		log.Warningf("container: %s %s, using the default waking up page: %s", container.ID[:12], container.Name, err)
+	} else {
+		containerInfo.WakingPage = page
+	}
+	return containerInfo
+}
 
-		case event := <-eventChan:
-			if event == nil {
-				if watching {
-					client.RemoveEventListener(eventChan)
-					watching = false
-					client = nil
-				}
-				break
-			}
+// applySettings reads the container's settings, they come from its labels and
+// env but also from the config file, which may be reloaded.
+func applySettings(containerInfo *ContainerInfo, container *docker.Container) {
+	containerInfo.Group = containerGroup(container)
+	containerInfo.DependsOn = parseDependsOn(container)
+	containerInfo.Direct = isDirect(container)
 
-			if _, ok := idContainerInfo[event.ID]; ok {
-				// if event.Status == "start" || event.Status == "stop" || event.Status == "die" {
-				log.Printf("Received event %s for container %s", event.Status, event.ID[:12])
-
-				switch event.Status {
-				case "start":
-					idContainerInfo[event.ID].Running = true
-				case "die":
-					idContainerInfo[event.ID].Running = false
-				case "stop":
-					idContainerInfo[event.ID].Running = false
-				}
-			}
-		}
+	if err := parseSleepPolicy(containerInfo, container); err != nil {
+		log.Warningf("container: %s %s, %s", container.ID[:12], container.Name, err)
+	}
+	if upstream, err := parseUpstream(container); err != nil {
+		log.Warningf("container: %s %s, using the default upstream: %s", container.ID[:12], container.Name, err)
+		containerInfo.Upstream = nil
+	} else {
+		containerInfo.Upstream = upstream
 	}
 }
 
+// stopInactiveContainers puts to sleep the containers, or whole groups, whose
+// entry points have been idle for long enough.
 func stopInactiveContainers() {
-	for _, c := range hostContainerInfo {
-		d := time.Now().Sub(c.LastAccess)
-		if d.Seconds() > float64(AutoSleepIn) {
-			if container, er := client.InspectContainer(c.Name); er != nil {
-				log.Errorln(er)
-			} else if container.State.Running {
-				log.Println("stopping container: ", c.ID[:12], c.Name, d.Seconds())
-				if err := client.StopContainer(container.ID, StopTimeout); err != nil {
-					log.Errorln("Error stopping container: ", c.ID[:12], c.Name, err)
-				} else {
-					log.Println("Stopped container.", c.ID[:12], c.Name)
+	idle := []sleepUnit{}
+	idleTimes := []time.Duration{}
+	for _, unit := range sleepUnits() {
+		if d, ok := idleFor(unit); ok {
+			idle = append(idle, unit)
+			idleTimes = append(idleTimes, d)
+		}
+	}
+
+	isBusy := busyUnits(idle)
+	for i, unit := range idle {
+		if isBusy[i] {
+			// busy with non HTTP work counts as activity
+			for _, c := range unit.members {
+				if c.entryPoint() && c.unitKey() == unit.key {
+					c.touch(time.Now())
 				}
 			}
+			continue
+		}
+		if _, ok := idleFor(unit); !ok {
+			// accessed while checking the stats
+			continue
+		}
+		putToSleep(unit, idleTimes[i], SleepReasonIdle)
+	}
+}
+
+// putToSleep puts the unit's containers to sleep in reverse wake up order,
+// dependencies go last.
+func putToSleep(unit sleepUnit, d time.Duration, reason string) {
+	for j := len(unit.members) - 1; j >= 0; j-- {
+		c := unit.members[j]
+		if c.entryPoint() && c.unitKey() != unit.key {
+			// put to sleep by its own unit
+			continue
+		}
+		if neededElsewhere(c, unit.members) {
+			continue
+		}
+		sleepContainer(c, d, reason)
+		prewarmWasted(c, time.Now())
+	}
+}
+
+func sleepContainer(c *ContainerInfo, d time.Duration, reason string) {
+	if container, er := dockerClient().InspectContainer(c.Name); countDockerError(er) != nil {
+		log.Errorln(er)
+	} else if c.SleepMode == SleepModePause && container.State.Running && !container.State.Paused {
+		log.Println("pausing container: ", c.ID[:12], c.Name, d.Seconds())
+		if err := dockerClient().PauseContainer(container.ID); countDockerError(err) != nil {
+			log.Errorln("Error pausing container: ", c.ID[:12], c.Name, err)
+		} else {
+			c.mu.Lock()
+			c.Paused = true
+			c.SleepReason, c.SleptAt = reason, time.Now()
+			c.mu.Unlock()
+			log.Println("Paused container.", c.ID[:12], c.Name)
+		}
+	} else if c.SleepMode == SleepModeStop && container.State.Running {
+		log.Println("stopping container: ", c.ID[:12], c.Name, d.Seconds())
+		if err := dockerClient().StopContainer(container.ID, uint(config().StopTimeout.Seconds())); countDockerError(err) != nil {
+			log.Errorln("Error stopping container: ", c.ID[:12], c.Name, err)
+		} else {
+			c.mu.Lock()
+			c.Running = false
+			c.Paused = false
+			c.Ready = false
+			c.SleepReason, c.SleptAt = reason, time.Now()
+			c.mu.Unlock()
+			log.Println("Stopped container.", c.ID[:12], c.Name)
 		}
 	}
 }
 
 func proxy(w http.ResponseWriter, r *http.Request) {
 
-	u, _ := url.Parse("http://127.0.0.1:8080")
+	currentContainerInfo := lookupHost(r.Host)
 
-	currentContainerInfo := hostContainerInfo[r.Host]
+	if r.URL.Path == StatusPath {
+		serveStatus(w, r, currentContainerInfo)
+		return
+	}
 
 	if currentContainerInfo != nil {
-		// set LastAccess
-		currentContainerInfo.LastAccess = time.Now()
+		cold := !currentContainerInfo.ready()
+		countRequest(currentContainerInfo, cold)
+		if entry := logEntry(w); entry != nil {
+			entry.ContainerID = currentContainerInfo.ID[:12]
+			entry.Cold = cold
+		}
+
+		rules := currentContainerInfo.Ignore
+		ignored := rules != nil && rules.matches(r)
+
+		if !ignored || rules.Touch {
+			now := time.Now()
+			currentContainerInfo.touch(now)
+			recordAccess(currentContainerInfo, now)
+		}
+
+		if ignored && !rules.Wake && !currentContainerInfo.ready() {
+			rules.respond(w)
+			return
+		}
 
-		if !currentContainerInfo.Running {
-			log.Println("starting container: ", currentContainerInfo.ID[:12], currentContainerInfo.Name)
-			startContainer(currentContainerInfo)
+		if wantsWakingPage(r) && !currentContainerInfo.ready() {
+			// browsers get the waking up page right away, it reloads once the container is ready
+			wakeInBackground(currentContainerInfo)
+			serveWakingPage(w, r, currentContainerInfo)
+			return
 		}
+
+		if !currentContainerInfo.ready() {
+			start := time.Now()
+			err := waitForWake(currentContainerInfo)
+			if entry := logEntry(w); entry != nil {
+				entry.WakeWait = milliseconds(time.Now().Sub(start))
+			}
+			if err != nil {
+				if err == errQueueFull || err == errQueueTimeout {
+					w.Header().Set("Retry-After", strconv.Itoa(QueueRetryAfter))
+				}
+				http.Error(w, fmt.Sprintf("autosleep: unable to wake up %s: %s", r.Host, err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	u, err := upstreamURL(currentContainerInfo)
+	if err != nil {
+		log.Errorln(err)
+		http.Error(w, fmt.Sprintf("autosleep: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	// always set, a client could send its own to pass as https
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set("X-Forwarded-Proto", proto)
+
+	if isUpgrade(r) {
+		tunnel(w, r, u, currentContainerInfo)
+		return
 	}
 
 	proxy := http.StripPrefix("", httputil.NewSingleHostReverseProxy(u))
@@ -214,16 +518,64 @@ func proxy(w http.ResponseWriter, r *http.Request) {
 	proxy.ServeHTTP(w, r)
 }
 
-func startContainer(containerInfo *ContainerInfo) {
+// startContainer starts the container and waits for it to be ready, using its
+// readiness probe if it has one, otherwise waiting -start-wait.
+// Paused containers are unpaused, they're ready right away.
+func startContainer(containerInfo *ContainerInfo) error {
+	containerInfo.mu.Lock()
+	paused := containerInfo.Running && containerInfo.Paused
+	containerInfo.mu.Unlock()
+
+	if paused {
+		if err := dockerClient().UnpauseContainer(containerInfo.ID); countDockerError(err) != nil {
+			return err
+		}
+		containerInfo.mu.Lock()
+		containerInfo.Paused = false
+		containerInfo.SleepReason = ""
+		containerInfo.mu.Unlock()
+		log.Warnf("unpaused container: %s %s", containerInfo.ID[:12], containerInfo.Name)
+		return nil
+	}
+
 	var hostConfig docker.HostConfig
 
 	hostConfig.PortBindings = containerInfo.PortBinding
 
-	if err := client.StartContainer(containerInfo.ID, &hostConfig); err != nil {
-		log.Errorln(err)
+	// not ready until its probe passes, a failed probe is retried by the next wake up
+	containerInfo.mu.Lock()
+	containerInfo.Ready = false
+	containerInfo.mu.Unlock()
+	if err := dockerClient().StartContainer(containerInfo.ID, &hostConfig); err != nil {
+		if _, ok := err.(*docker.ContainerAlreadyRunning); !ok {
+			return countDockerError(err)
+		}
+	}
+	containerInfo.mu.Lock()
+	containerInfo.Running = true
+	containerInfo.Paused = false
+	containerInfo.SleepReason = ""
+	containerInfo.mu.Unlock()
+
+	container, err := dockerClient().InspectContainer(containerInfo.ID)
+	if countDockerError(err) != nil {
+		return err
+	}
+	containerInfo.mu.Lock()
+	containerInfo.StartedAt = container.State.StartedAt
+	containerInfo.mu.Unlock()
+	updateTarget(containerInfo, container)
+
+	if containerInfo.Probe == nil {
+		time.Sleep(config().StartContainerWait)
+	} else if err := waitReady(containerInfo, container); err != nil {
+		return err
 	}
-	time.Sleep(StartContainerWait * time.Second)
-	fmt.Printf("started container! %s, %s :)\n", containerInfo.ID[:12], containerInfo.Name)
+	containerInfo.mu.Lock()
+	containerInfo.Ready = true
+	containerInfo.mu.Unlock()
+	log.Warnf("started container: %s %s", containerInfo.ID[:12], containerInfo.Name)
+	return nil
 }
 
 // splitKeyValueSlice takes a string slice where values are of the form
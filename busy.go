@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// thresholds above which an idle container counts as busy, e.g. a worker
+// halfway through an import, read like the other settings:
+//
+//	docker run -l autosleep.busy.cpu=20 -l autosleep.busy.net=100k -l autosleep.busy.window=1m ...
+const (
+	BusyCPUSetting    = "busy.cpu"    // percent of one CPU
+	BusyNetSetting    = "busy.net"    // bytes per second received and sent
+	BusyBlkIOSetting  = "busy.blkio"  // bytes per second read and written
+	BusyWindowSetting = "busy.window" // how long the stats are sampled for
+)
+
+const BusyWindow = 30 * time.Second
+
+// StatsTimeout bounds how long the daemon takes to answer a stats request.
+const StatsTimeout = 10 * time.Second
+
+type BusyThresholds struct {
+	CPU    float64
+	Net    float64
+	BlkIO  float64
+	Window time.Duration
+}
+
+// parseBusyThresholds returns nil if the container has no thresholds.
+func parseBusyThresholds(container *docker.Container) (*BusyThresholds, error) {
+	b := &BusyThresholds{Window: BusyWindow}
+	set := false
+
+	for _, s := range []struct {
+		key   string
+		value *float64
+		parse func(string) (float64, error)
+	}{
+		{BusyCPUSetting, &b.CPU, func(v string) (float64, error) { return strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64) }},
+		{BusyNetSetting, &b.Net, parseBytes},
+		{BusyBlkIOSetting, &b.BlkIO, parseBytes},
+	} {
+		v := containerSetting(container, s.key)
+		if v == "" {
+			continue
+		}
+		f, err := s.parse(v)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid %s=%s", s.key, v)
+		}
+		*s.value = f
+		set = true
+	}
+
+	if v := containerSetting(container, BusyWindowSetting); v != "" {
+		d, err := parseSeconds(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s=%s", BusyWindowSetting, v)
+		}
+		b.Window = d
+	}
+
+	if !set {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// parseBytes parses sizes like 512, 100k, 2m or 1g.
+func parseBytes(v string) (float64, error) {
+	multiplier := 1.0
+	switch strings.ToLower(v[len(v)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		v = v[:len(v)-1]
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f * multiplier, err
+}
+
+// busy samples the container's stats over its window and tells whether it
+// crossed any of its thresholds.
+func busy(c *ContainerInfo) (bool, error) {
+	statsC := make(chan *docker.Stats)
+	done := make(chan bool)
+	errC := make(chan error, 1)
+	go func() {
+		// the client keeps the timeout as a deadline on the whole stream, it
+		// has to outlast the window which is ended through done
+		errC <- dockerClient().Stats(docker.StatsOptions{ID: c.ID, Stats: statsC, Stream: true, Done: done, Timeout: c.Busy.Window + StatsTimeout})
+	}()
+
+	var first, last *docker.Stats
+	ended := false // the stream ended before the window did
+	timeout := time.After(c.Busy.Window)
+sample:
+	for {
+		select {
+		case s, ok := <-statsC:
+			if !ok {
+				ended = true
+				break sample
+			}
+			if first == nil {
+				first = s
+			} else {
+				last = s
+			}
+		case <-timeout:
+			break sample
+		}
+	}
+	close(done)
+	for range statsC {
+		// Stats closes the channel once it's done
+	}
+	err := <-errC
+	if ended {
+		countDockerError(err)
+	} else {
+		// ending the stream through done, or its deadline right after, isn't a failure
+		err = nil
+	}
+
+	if first == nil || last == nil {
+		if err == nil {
+			err = fmt.Errorf("not enough stats samples")
+		}
+		return false, err
+	}
+
+	elapsed := last.Read.Sub(first.Read)
+	if elapsed <= 0 {
+		return false, fmt.Errorf("not enough stats samples")
+	}
+	seconds := elapsed.Seconds()
+
+	cpu := float64(last.CPUStats.CPUUsage.TotalUsage-first.CPUStats.CPUUsage.TotalUsage) / float64(elapsed.Nanoseconds()) * 100
+	net := float64(netTotal(last)-netTotal(first)) / seconds
+	blkio := float64(blkioTotal(last)-blkioTotal(first)) / seconds
+
+	b := c.Busy
+	if (b.CPU > 0 && cpu >= b.CPU) || (b.Net > 0 && net >= b.Net) || (b.BlkIO > 0 && blkio >= b.BlkIO) {
+		log.Printf("container %s %s is busy, cpu: %.1f%%, net: %.0f B/s, blkio: %.0f B/s", c.ID[:12], c.Name, cpu, net, blkio)
+		return true, nil
+	}
+	return false, nil
+}
+
+// netTotal sums the bytes received and sent, over every interface on daemons
+// reporting them per interface, older ones report a single network.
+func netTotal(s *docker.Stats) uint64 {
+	total := s.Network.RxBytes + s.Network.TxBytes
+	for _, n := range s.Networks {
+		total += n.RxBytes + n.TxBytes
+	}
+	return total
+}
+
+func blkioTotal(s *docker.Stats) uint64 {
+	var total uint64
+	for _, e := range s.BlkioStats.IOServiceBytesRecursive {
+		if e.Op == "Total" {
+			total += e.Value
+		}
+	}
+	return total
+}
+
+// busyUnits checks the idle units for members still busy with non HTTP work,
+// all at once since each check takes a whole window.
+func busyUnits(units []sleepUnit) []bool {
+	result := make([]bool, len(units))
+
+	// members of a unit are checked at once and may all find it busy
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, unit := range units {
+		for _, m := range unit.members {
+			if m.Busy == nil || !m.awake() {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, m *ContainerInfo) {
+				defer wg.Done()
+				b, err := busy(m)
+				if err != nil {
+					log.Errorln("Error reading stats for container: ", m.ID[:12], m.Name, err)
+				}
+				if b {
+					mu.Lock()
+					result[i] = true
+					mu.Unlock()
+				}
+			}(i, m)
+		}
+	}
+	wg.Wait()
+	return result
+}
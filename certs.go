@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// labels pointing to a certificate and key on the autosleep host for the
+// container's VIRTUAL_HOST, they take precedence over the certificate directory.
+const (
+	TLSCertLabel = "autosleep.tls.cert"
+	TLSKeyLabel  = "autosleep.tls.key"
+)
+
+// CertReloadInterval is how often the certificate files are checked for changes.
+const CertReloadInterval = 30 * time.Second
+
+var (
+	HTTPSAddr string
+	certs     = &certStore{}
+)
+
+// certStore picks certificates by SNI. The certificate directory follows
+// nginx-proxy's layout: foo.example.com.crt and foo.example.com.key, with
+// default.crt and default.key used when nothing else matches.
+type certStore struct {
+	mu        sync.RWMutex
+	byName    map[string]*tls.Certificate
+	def       *tls.Certificate
+	signature string // files and modification times of the loaded certificates
+}
+
+type certFile struct {
+	names    []string // names the certificate is used for, besides its own DNS names
+	certPath string
+	keyPath  string
+}
+
+// certFiles lists the certificates in -certdir and those set by container labels.
+func certFiles() []certFile {
+	files := []certFile{}
+
+	matches, _ := filepath.Glob(filepath.Join(config().CertDir, "*.crt"))
+	for _, certPath := range matches {
+		name := strings.TrimSuffix(filepath.Base(certPath), ".crt")
+		keyPath := strings.TrimSuffix(certPath, ".crt") + ".key"
+		if _, err := os.Stat(keyPath); err != nil {
+			log.Warningf("certificate %s has no key %s", certPath, keyPath)
+			continue
+		}
+		files = append(files, certFile{names: []string{strings.ToLower(name)}, certPath: certPath, keyPath: keyPath})
+	}
+
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+	for host, c := range hostContainerInfo {
+		if c.TLSCert != "" {
+			files = append(files, certFile{names: []string{strings.ToLower(host)}, certPath: c.TLSCert, keyPath: c.TLSKey})
+		}
+	}
+	return files
+}
+
+func signature(files []certFile) string {
+	parts := []string{}
+	for _, f := range files {
+		for _, path := range []string{f.certPath, f.keyPath} {
+			info, err := os.Stat(path)
+			if err != nil {
+				parts = append(parts, path)
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+		}
+		parts = append(parts, strings.Join(f.names, ","))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\n")
+}
+
+// reload loads the certificates again if any of the files changed.
+func (s *certStore) reload() {
+	files := certFiles()
+	sig := signature(files)
+
+	s.mu.RLock()
+	unchanged := sig == s.signature
+	s.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	byName := make(map[string]*tls.Certificate)
+	var def *tls.Certificate
+	for _, f := range files {
+		cert, err := loadCertificate(f.certPath, f.keyPath)
+		if err != nil {
+			log.Errorln("Error loading certificate: ", f.certPath, err)
+			continue
+		}
+		if len(f.names) == 1 && f.names[0] == "default" {
+			def = cert
+			continue
+		}
+		for _, name := range cert.Leaf.DNSNames {
+			if _, ok := byName[strings.ToLower(name)]; !ok {
+				byName[strings.ToLower(name)] = cert
+			}
+		}
+		// explicit names win over the names found in other certificates
+		for _, name := range f.names {
+			byName[name] = cert
+		}
+	}
+
+	s.mu.Lock()
+	s.byName = byName
+	s.def = def
+	s.signature = sig
+	s.mu.Unlock()
+	log.Println("loaded certificates: ", len(files))
+}
+
+func loadCertificate(certPath, keyPath string) (*tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// getCertificate picks the certificate for the SNI name: an exact match, then
+// a wildcard or parent domain certificate, then the default one.
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	for i := strings.Index(name, "."); i >= 0; i = strings.Index(name, ".") {
+		parent := name[i+1:]
+		if cert, ok := s.byName["*."+parent]; ok {
+			return cert, nil
+		}
+		if cert, ok := s.byName[parent]; ok {
+			return cert, nil
+		}
+		name = parent
+	}
+	if s.def != nil {
+		return s.def, nil
+	}
+	return nil, fmt.Errorf("no certificate for %s", hello.ServerName)
+}
+
+func watchCertificates() {
+	for {
+		time.Sleep(CertReloadInterval)
+		certs.reload()
+	}
+}
+
+// serveHTTPS terminates TLS for all hosts and hands the requests to the same handler as plain HTTP.
+func serveHTTPS(s *http.Server) error {
+	certs.reload()
+	go watchCertificates()
+
+	l, err := net.Listen("tcp", HTTPSAddr)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{GetCertificate: certs.getCertificate}
+	return s.Serve(tls.NewListener(l, config))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand talking to a running autosleep through its admin API.
+type command struct {
+	usage string
+	run   func(c *adminClient, args []string, flags *commandFlags) error
+}
+
+type commandFlags struct {
+	json     bool
+	sleeping bool
+}
+
+var commands = map[string]command{
+	"status": {"status [host]", runStatus},
+	"ls":     {"ls [--sleeping]", runList},
+	"wake":   {"wake <host>", runWake},
+	"sleep":  {"sleep <host>", runSleep},
+}
+
+// runCommand runs the subcommand and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd := commands[name]
+	flags := &commandFlags{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addr := fs.String("admin", DefaultAdminAddr, "admin API address of the running autosleep")
+	fs.BoolVar(&flags.json, "json", false, "print JSON instead of a table")
+	if name == "ls" {
+		fs.BoolVar(&flags.sleeping, "sleeping", false, "only list the sleeping hosts")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: autosleep %s [options]\n", cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	// flags may come after the host too
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	c, err := newAdminClient(*addr)
+	if err == nil {
+		err = cmd.run(c, positional, flags)
+	}
+	if err == errUsage {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "autosleep %s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+func runStatus(c *adminClient, args []string, flags *commandFlags) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	if len(args) == 1 {
+		var host hostStatus
+		if err := c.do("GET", "/hosts/"+args[0], &host); err != nil {
+			return err
+		}
+		return printHosts(os.Stdout, []hostStatus{host}, flags)
+	}
+
+	var hosts []hostStatus
+	if err := c.do("GET", "/hosts", &hosts); err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, h := range hosts {
+		counts[h.State]++
+	}
+	if flags.json {
+		return printJSON(os.Stdout, counts)
+	}
+	fmt.Printf("%d hosts: %d running, %d waking, %d unready, %d paused, %d stopped\n",
+		len(hosts), counts["running"], counts["waking"], counts["unready"], counts["paused"], counts["stopped"])
+	return nil
+}
+
+func runList(c *adminClient, args []string, flags *commandFlags) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	var hosts []hostStatus
+	if err := c.do("GET", "/hosts", &hosts); err != nil {
+		return err
+	}
+	if flags.sleeping {
+		sleeping := []hostStatus{}
+		for _, h := range hosts {
+			if h.State == "paused" || h.State == "stopped" {
+				sleeping = append(sleeping, h)
+			}
+		}
+		hosts = sleeping
+	}
+	return printHosts(os.Stdout, hosts, flags)
+}
+
+func runWake(c *adminClient, args []string, flags *commandFlags) error {
+	return runAction(c, args, flags, "wake")
+}
+
+func runSleep(c *adminClient, args []string, flags *commandFlags) error {
+	return runAction(c, args, flags, "sleep")
+}
+
+func runAction(c *adminClient, args []string, flags *commandFlags, action string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var host hostStatus
+	if err := c.do("POST", "/hosts/"+args[0]+"/"+action, &host); err != nil {
+		return err
+	}
+	return printHosts(os.Stdout, []hostStatus{host}, flags)
+}
+
+func printHosts(out io.Writer, hosts []hostStatus, flags *commandFlags) error {
+	if flags.json {
+		return printJSON(out, hosts)
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATE\tMODE\tCONTAINER\tNAME\tLAST ACCESS\tSLEEPS")
+	for _, h := range hosts {
+		sleeps := "never"
+		if h.State == "paused" || h.State == "stopped" {
+			sleeps = "-"
+		} else if h.IdleDeadline != nil {
+			sleeps = relativeTime(*h.IdleDeadline, now)
+		}
+		state := h.State
+		if h.Error != "" {
+			state += " (" + h.Error + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.12s\t%s\t%s\t%s\n", h.Host, state, h.SleepMode, h.ID, h.Name, relativeTime(h.LastAccess, now), sleeps)
+	}
+	return tw.Flush()
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(b))
+	return err
+}
+
+// relativeTime formats t like "5m ago" or "in 2h".
+func relativeTime(t, now time.Time) string {
+	d := t.Sub(now)
+	if d < 0 {
+		return shortDuration(-d) + " ago"
+	}
+	return "in " + shortDuration(d)
+}
+
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// adminClient talks to the admin API of a running autosleep.
+type adminClient struct {
+	http *http.Client
+	base string
+}
+
+func newAdminClient(addr string) (*adminClient, error) {
+	proto, laddr, err := parseHost(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proto != "unix" && proto != "tcp" {
+		return nil, fmt.Errorf("invalid admin address %s, expected unix:// or tcp://", addr)
+	}
+
+	dial := func(network, _ string) (net.Conn, error) {
+		return net.Dial(proto, laddr)
+	}
+	return &adminClient{
+		http: &http.Client{Transport: &http.Transport{Dial: dial}},
+		base: "http://autosleep",
+	}, nil
+}
+
+// do calls the admin API and decodes the JSON response into v.
+func (c *adminClient) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("is autosleep running? %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return errors.New(strings.TrimSpace(e.Error))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
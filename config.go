@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// Config holds the settings from the command line and the config file, flags
+// given on the command line win over the file.
+type Config struct {
+	File string
+
+	AutoSleepIn        int
+	StopTimeout        time.Duration
+	StartContainerWait time.Duration
+	ReadWriteTimeout   time.Duration
+	Direct             bool
+	Upstream           string
+	HTTPAddr           string
+	HTTPSAddr          string
+	CertDir            string
+	AdminAddr          string
+	MetricsAddr        string
+	Prewarm            bool
+	PrewarmConfidence  float64
+	PrewarmLead        time.Duration
+	QueueDepth         int
+	QueueWait          time.Duration
+	AccessLog          string
+	AccessLogMaxSize   int64
+	AccessLogBackups   int
+	StateFile          string
+	DockerHost         string
+	DockerTLSVerify    bool
+	DockerCertPath     string
+
+	Hosts map[string]map[string]string // maps host to its settings, used when its container doesn't set them
+
+	upstream *url.URL
+	flags    *flag.FlagSet
+}
+
+// restartFlags can't change without restarting autosleep, a reload keeps their previous values.
+var restartFlags = []string{
+	"http", "https", "admin", "metrics", "read-write-timeout", "queue-wait", "prewarm",
+	"access-log", "access-log-max-size", "access-log-backups", "state",
+	"docker-host", "docker-tls-verify", "docker-cert-path",
+}
+
+// hostSettingKeys are the settings a [hosts."<host>"] table may set.
+var hostSettingKeys = []string{
+	IdleSetting, EnabledSetting, ModeSetting, AwakeSetting, GroupSetting, DependsOnSetting, UpstreamSetting,
+	BusyCPUSetting, BusyNetSetting, BusyBlkIOSetting, BusyWindowSetting,
+	IgnorePathSetting, IgnoreAgentSetting, IgnoreCIDRSetting, IgnoreTouchSetting, IgnoreWakeSetting,
+	IgnoreStatusSetting, IgnoreRetryAfterSetting, IgnoreBodySetting,
+	QueueDepthSetting, QueueWaitSetting,
+}
+
+var (
+	configMu      sync.RWMutex
+	currentConfig *Config // swapped on reload, read it with config()
+)
+
+// config returns the current config, settings that can be reloaded are read
+// from it. A reload swaps it for a new one, it's never modified.
+func config() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return currentConfig
+}
+
+func (c *Config) define(fs *flag.FlagSet) {
+	fs.StringVar(&c.File, "config", "", "TOML config file, reloaded on SIGHUP")
+	fs.IntVar(&c.AutoSleepIn, "autosleepin", 60*30, "auto sleep containers in this many seconds")
+	fs.DurationVar(&c.StopTimeout, "stop-timeout", 5*time.Second, "how long docker waits for a container to stop before killing it")
+	fs.DurationVar(&c.StartContainerWait, "start-wait", 5*time.Second, "how long to wait after starting a container without a readiness probe")
+	fs.DurationVar(&c.ReadWriteTimeout, "read-write-timeout", 10*time.Second, "read and write timeout of the http(s) servers")
+	fs.BoolVar(&c.Direct, "direct", false, "proxy straight to the containers instead of the upstream")
+	fs.StringVar(&c.Upstream, "upstream", "http://127.0.0.1:8080", "upstream (nginx) requests are proxied to")
+	fs.StringVar(&c.HTTPAddr, "http", ":80", "listen for http on this address")
+	fs.StringVar(&c.HTTPSAddr, "https", "", "terminate TLS on this address, e.g. :443")
+	fs.StringVar(&c.CertDir, "certdir", "/etc/autosleep/certs", "directory with the host.crt and host.key certificates")
+	fs.StringVar(&c.AdminAddr, "admin", DefaultAdminAddr, "admin API address, unix:// socket or tcp://, empty to disable")
+	fs.StringVar(&c.MetricsAddr, "metrics", "", "also serve the Prometheus /metrics on this address, e.g. :9180")
+	fs.BoolVar(&c.Prewarm, "prewarm", false, "wake up containers before the times they usually get traffic")
+	fs.Float64Var(&c.PrewarmConfidence, "prewarm-confidence", 0.6, "share of past weeks with traffic needed to pre-warm")
+	fs.DurationVar(&c.PrewarmLead, "prewarm-lead", 5*time.Minute, "how long before the expected traffic to pre-warm")
+	fs.IntVar(&c.QueueDepth, "queue-depth", 100, "maximum number of requests waiting for a container to wake up")
+	fs.DurationVar(&c.QueueWait, "queue-wait", 30*time.Second, "maximum time a request waits for a container to wake up")
+	fs.StringVar(&c.AccessLog, "access-log", "", "write a JSON lines access log to this file, - for stdout")
+	fs.Int64Var(&c.AccessLogMaxSize, "access-log-max-size", 100, "rotate the access log once it reaches this many megabytes")
+	fs.IntVar(&c.AccessLogBackups, "access-log-backups", 3, "number of rotated access logs to keep")
+	fs.StringVar(&c.StateFile, "state", DefaultStateFile, "file keeping the idle timers and stats across restarts, empty to disable")
+	fs.StringVar(&c.DockerHost, "docker-host", defaultDockerHost(), "docker daemon address, unix:// socket or tcp://, defaults to $DOCKER_HOST")
+	fs.BoolVar(&c.DockerTLSVerify, "docker-tls-verify", os.Getenv("DOCKER_TLS_VERIFY") != "", "use TLS and verify the docker daemon, defaults to $DOCKER_TLS_VERIFY")
+	fs.StringVar(&c.DockerCertPath, "docker-cert-path", defaultDockerCertPath(), "directory with ca.pem, cert.pem and key.pem, defaults to $DOCKER_CERT_PATH")
+}
+
+// loadConfig parses the command line into fs, then the config file it
+// points to, if any, and validates the result.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := &Config{flags: fs}
+	c.define(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if c.File != "" {
+		values, hosts, err := parseConfigFile(c.File)
+		if err != nil {
+			return nil, err
+		}
+		given := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		for name, v := range values {
+			if name == "config" || fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("%s: unknown setting %s", c.File, name)
+			}
+			if given[name] {
+				continue
+			}
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s = %s: %s", c.File, name, v, err)
+			}
+		}
+		c.Hosts = hosts
+	}
+
+	if err := c.validate(); err != nil {
+		if c.File != "" {
+			return nil, fmt.Errorf("%s: %s", c.File, err)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.AutoSleepIn <= 0:
+		return fmt.Errorf("autosleepin must be positive")
+	case c.StopTimeout < 0 || c.StartContainerWait < 0:
+		return fmt.Errorf("stop-timeout and start-wait can't be negative")
+	case c.ReadWriteTimeout <= 0:
+		return fmt.Errorf("read-write-timeout must be positive")
+	case c.QueueDepth <= 0 || c.QueueWait <= 0:
+		return fmt.Errorf("queue-depth and queue-wait must be positive")
+	case c.PrewarmConfidence <= 0 || c.PrewarmConfidence > 1:
+		return fmt.Errorf("prewarm-confidence must be between 0 and 1")
+	case c.HTTPAddr == "":
+		return fmt.Errorf("http can't be empty")
+	}
+
+	if proto, _, err := parseHost(c.DockerHost); err != nil {
+		return fmt.Errorf("invalid docker-host %s: %s", c.DockerHost, err)
+	} else if proto != "unix" && proto != "tcp" {
+		return fmt.Errorf("invalid docker-host %s, expected unix:// or tcp://", c.DockerHost)
+	}
+
+	var err error
+	if c.upstream, err = url.Parse(c.Upstream); err != nil || c.upstream.Host == "" {
+		return fmt.Errorf("invalid upstream %s", c.Upstream)
+	}
+
+	for host, settings := range c.Hosts {
+		// check the values the way they're read from labels
+		labels := make(map[string]string)
+		for key, v := range settings {
+			if !knownHostSetting(key) {
+				return fmt.Errorf("[hosts.%q] unknown setting %s", host, key)
+			}
+			labels["autosleep."+key] = v
+		}
+		container := &docker.Container{Config: &docker.Config{Labels: labels}}
+		if err := parseSleepPolicy(&ContainerInfo{}, container); err != nil {
+			return fmt.Errorf("[hosts.%q] %s", host, err)
+		}
+		if _, err := parseUpstream(container); err != nil {
+			return fmt.Errorf("[hosts.%q] %s", host, err)
+		}
+	}
+	return nil
+}
+
+func knownHostSetting(key string) bool {
+	for _, k := range hostSettingKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfig makes the config current. At startup it also sets the
+// restartFlags' globals, on reload those keep their previous values.
+func applyConfig(c *Config, startup bool) {
+	if startup {
+		ReadWriteTimeout = c.ReadWriteTimeout
+		HTTPAddr = c.HTTPAddr
+		HTTPSAddr = c.HTTPSAddr
+		AdminAddr = c.AdminAddr
+		MetricsAddr = c.MetricsAddr
+		Prewarm = c.Prewarm
+		QueueWait = c.QueueWait
+		AccessLog = c.AccessLog
+		AccessLogMaxSize = c.AccessLogMaxSize
+		AccessLogBackups = c.AccessLogBackups
+		StateFile = c.StateFile
+		DockerHost = c.DockerHost
+		DockerTLSVerify = c.DockerTLSVerify
+		DockerCertPath = c.DockerCertPath
+	} else {
+		for _, name := range restartFlags {
+			applied := currentConfig.flags.Lookup(name).Value.String()
+			if applied != c.flags.Lookup(name).Value.String() {
+				log.Warningf("%s changed, restart autosleep to apply it", name)
+				// keep what's in use so the next reload still warns about it
+				c.flags.Set(name, applied)
+			}
+		}
+	}
+
+	configMu.Lock()
+	currentConfig = c
+	configMu.Unlock()
+}
+
+// hostSetting returns the setting from the config file's table of the first
+// host that has it.
+func hostSetting(hosts []string, key string) string {
+	c := config()
+	if c == nil {
+		// validating the first config, there's no config file applied yet
+		return ""
+	}
+	for _, host := range hosts {
+		if v, ok := c.Hosts[host][key]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// watchConfig reloads the config on SIGHUP, an invalid config is logged and
+// the previous one kept. Containers are rediscovered so their settings are
+// read again, listeners and connections are left alone.
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		c, err := loadConfig(fs, os.Args[1:])
+		if err != nil {
+			log.Errorf("invalid configuration, keeping the previous one: %s", err)
+			continue
+		}
+		applyConfig(c, false)
+		getAllDockerContainers()
+		updateTCPListeners()
+		log.Println("reloaded the configuration")
+	}
+}
+
+// parseConfigFile reads the subset of TOML autosleep needs: key = value pairs
+// named after the flags, and [hosts."<host>"] tables of settings, e.g.
+//
+//	upstream = "http://127.0.0.1:8080"
+//	queue-wait = "20s"
+//
+//	[hosts."foo.example.com"]
+//	idle = "10m"
+//	busy.cpu = 5
+//
+//	[hosts."bar.example.com".busy]
+//	cpu = 5
+//
+// Dotted keys and sub-tables of a host are flattened into setting names like
+// busy.cpu. Values are strings, numbers or booleans, arrays and inline tables
+// aren't supported.
+func parseConfigFile(path string) (map[string]string, map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	hosts := make(map[string]map[string]string)
+	table := values
+	prefix := ""                    // of the keys in a host's sub-table, e.g. busy.
+	tables := make(map[string]bool) // tables defined so far, by host and prefix
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			host, sub, err := parseHostsTable(line)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+			if tables[host+"\x00"+sub] {
+				return nil, nil, fmt.Errorf("%s:%d: %s defined twice", path, n, line)
+			}
+			tables[host+"\x00"+sub] = true
+			if _, ok := hosts[host]; !ok {
+				hosts[host] = make(map[string]string)
+			}
+			table = hosts[host]
+			prefix = ""
+			if sub != "" {
+				prefix = sub + "."
+			}
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key, err := parseConfigKey(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		v, err := parseConfigValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		key = prefix + key
+		if _, ok := table[key]; ok {
+			return nil, nil, fmt.Errorf("%s:%d: %s defined twice", path, n, key)
+		}
+		table[key] = v
+	}
+	return values, hosts, scanner.Err()
+}
+
+// parseHostsTable parses a [hosts."<host>"] or [hosts."<host>".<sub>] header
+// and returns the host and the sub-table, if any.
+func parseHostsTable(line string) (string, string, error) {
+	if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+		return "", "", fmt.Errorf("invalid table %s", line)
+	}
+	name := strings.TrimSpace(line[1 : len(line)-1])
+	if !strings.HasPrefix(name, "hosts.") {
+		return "", "", fmt.Errorf("unknown table [%s], only [hosts.\"<host>\"] tables are supported", name)
+	}
+	name = strings.TrimPrefix(name, "hosts.")
+
+	// TOML reads a bare hosts.foo.example.com as nested tables, not as a host
+	sub := ""
+	if !strings.HasPrefix(name, `"`) && strings.Contains(name, ".") {
+		return "", "", fmt.Errorf("invalid table %s, quote the host, e.g. [hosts.%q]", line, name)
+	}
+	if strings.HasPrefix(name, `"`) {
+		end := strings.Index(name[1:], `"`) + 1
+		if end > 0 && end < len(name)-1 {
+			if name[end+1] != '.' {
+				return "", "", fmt.Errorf("invalid table %s", line)
+			}
+			var err error
+			if sub, err = parseConfigKey(name[end+2:]); err != nil {
+				return "", "", err
+			}
+			name = name[:end+1]
+		}
+	}
+	host, err := parseConfigKey(name)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.ToLower(host), sub, nil
+}
+
+// parseConfigKey parses a bare or quoted key, dotted keys are kept as is
+// since settings like busy.cpu are named that way.
+func parseConfigKey(key string) (string, error) {
+	if strings.HasPrefix(key, `"`) {
+		return strconv.Unquote(key)
+	}
+	if key == "" {
+		return "", fmt.Errorf("missing key")
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' || r == '*') {
+			return "", fmt.Errorf("invalid key %s, quote it", key)
+		}
+	}
+	return key, nil
+}
+
+// parseConfigValue parses a string, number or boolean value, and drops the comment after it.
+func parseConfigValue(v string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := 1
+		for ; end < len(v); end++ {
+			if v[end] == '\\' {
+				end++
+			} else if v[end] == '"' {
+				break
+			}
+		}
+		if end >= len(v) {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		s, err := strconv.Unquote(v[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", v[:end+1])
+		}
+		value, rest = s, v[end+1:]
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		value, rest = v[1:end+1], v[end+2:]
+	default:
+		if i := strings.Index(v, "#"); i >= 0 {
+			v = v[:i]
+		}
+		value = strings.TrimSpace(v)
+		if _, err := strconv.ParseFloat(value, 64); err != nil && value != "true" && value != "false" {
+			return "", fmt.Errorf("invalid value %s, strings must be quoted", value)
+		}
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %s after the value", rest)
+	}
+	return value, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigValue(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{`"10m"`, "10m"},
+		{`"a # b"`, "a # b"}, // not a comment inside quotes
+		{`"a # b" # comment`, "a # b"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`'C:\path # raw'`, `C:\path # raw`},
+		{`'x' # comment`, "x"},
+		{`""`, ""},
+		{"5", "5"},
+		{"0.6 # confidence", "0.6"},
+		{"true", "true"},
+		{"false#comment", "false"},
+	}
+	for _, test := range tests {
+		got, err := parseConfigValue(test.v)
+		if err != nil {
+			t.Errorf("parseConfigValue(%s): %s", test.v, err)
+		} else if got != test.want {
+			t.Errorf("parseConfigValue(%s) = %q, want %q", test.v, got, test.want)
+		}
+	}
+}
+
+func TestParseConfigValueErrors(t *testing.T) {
+	for _, v := range []string{`"unterminated`, `"escaped end\"`, `'unterminated`, "bare", `"a" b`, `"\q"`, ""} {
+		if got, err := parseConfigValue(v); err == nil {
+			t.Errorf("parseConfigValue(%s) = %q, want an error", v, got)
+		}
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	values, hosts, err := parseConfigFile(writeConfig(t, dir, `
+# comment
+upstream = "http://127.0.0.1:8080" # trailing comment
+queue-wait = "20s"
+prewarm = true
+
+[hosts."Foo.Example.com"]
+idle = "10m"
+busy.cpu = 5
+ignore.path = "/health#z"
+
+[hosts."*.example.com".busy]
+cpu = 20
+blkio = "1m"
+
+[hosts.localhost]
+mode = "pause"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantValues := map[string]string{"upstream": "http://127.0.0.1:8080", "queue-wait": "20s", "prewarm": "true"}
+	if !equalSettings(values, wantValues) {
+		t.Errorf("values = %v, want %v", values, wantValues)
+	}
+	wantHosts := map[string]map[string]string{
+		"foo.example.com": {"idle": "10m", "busy.cpu": "5", "ignore.path": "/health#z"},
+		"*.example.com":   {"busy.cpu": "20", "busy.blkio": "1m"},
+		"localhost":       {"mode": "pause"},
+	}
+	if len(hosts) != len(wantHosts) {
+		t.Errorf("hosts = %v, want %v", hosts, wantHosts)
+	}
+	for host, want := range wantHosts {
+		if !equalSettings(hosts[host], want) {
+			t.Errorf("hosts[%q] = %v, want %v", host, hosts[host], want)
+		}
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"http = \":80\"\nhttp = \":81\"", "http defined twice"},
+		{"[hosts.\"a\"]\nidle = 5\nidle = 6", "idle defined twice"},
+		{"[hosts.\"a\"]\nidle = 5\n[hosts.\"A\"]", "defined twice"},
+		{"[hosts.\"a\"]\nbusy.cpu = 5\n[hosts.\"a\".busy]\ncpu = 6", "busy.cpu defined twice"},
+		{"[hosts.\"a\".busy]\n[hosts.\"a\".busy]", "defined twice"},
+		{"http", "expected key = value"},
+		{"http = :80", "strings must be quoted"},
+		{"[server]", "unknown table"},
+		{"[[hosts.\"a\"]]", "invalid table"},
+		{"[hosts.\"a\"x]", "invalid table"},
+		{"[hosts.bar.example.com]", `quote the host, e.g. [hosts."bar.example.com"]`},
+		{"a b = 1", "invalid key"},
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		_, _, err := parseConfigFile(writeConfig(t, dir, test.config))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("parseConfigFile(%q) = %v, want an error containing %q", test.config, err, test.err)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, dir, config string) string {
+	f, err := ioutil.TempFile(dir, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(config); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "autosleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func equalSettings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
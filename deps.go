@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// DependsOnSetting lists the names of the containers that must be up and ready
+// before the container starts, separated by commas, e.g. autosleep.depends_on=myapp_db
+const DependsOnSetting = "depends_on"
+
+func parseDependsOn(container *docker.Container) []string {
+	names := []string{}
+	for _, name := range strings.Split(containerSetting(container, DependsOnSetting), ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "/")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func containerName(c *ContainerInfo) string {
+	return strings.TrimPrefix(c.Name, "/")
+}
+
+// resolveDependencies links the known containers to the containers they depend
+// on, tracking those as well. Missing dependencies and cycles are reported
+// here, at discovery, and the containers involved refuse to wake up.
+func resolveDependencies(all map[string]*docker.Container, known map[string]*ContainerInfo) {
+	named := make(map[string]*ContainerInfo)
+	queue := []*ContainerInfo{}
+	for _, c := range idContainerInfo {
+		named[containerName(c)] = c
+		queue = append(queue, c)
+	}
+	sort.Sort(byName(queue))
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		for _, name := range c.DependsOn {
+			d, ok := named[name]
+			if !ok {
+				container, ok := all[name]
+				if !ok {
+					c.DependsError = fmt.Errorf("container %s depends on %s, which doesn't exist", containerName(c), name)
+					log.Errorln(c.DependsError)
+					continue
+				}
+				d = containerInfoFor(known, container)
+				idContainerInfo[d.ID] = d
+				named[name] = d
+				queue = append(queue, d)
+			}
+			c.Dependencies = append(c.Dependencies, d)
+		}
+	}
+
+	findDependencyCycles()
+}
+
+// findDependencyCycles marks every container that is part of a dependency cycle.
+func findDependencyCycles() {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*ContainerInfo]int)
+	stack := []*ContainerInfo{}
+
+	var visit func(c *ContainerInfo)
+	visit = func(c *ContainerInfo) {
+		state[c] = visiting
+		stack = append(stack, c)
+		for _, d := range c.Dependencies {
+			switch state[d] {
+			case unvisited:
+				visit(d)
+			case visiting:
+				i := len(stack) - 1
+				for stack[i] != d {
+					i--
+				}
+				cycle := stack[i:]
+				names := []string{}
+				for _, x := range cycle {
+					names = append(names, containerName(x))
+				}
+				err := fmt.Errorf("dependency cycle: %s -> %s", strings.Join(names, " -> "), containerName(d))
+				log.Errorln(err)
+				for _, x := range cycle {
+					x.DependsError = err
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[c] = visited
+	}
+
+	containers := []*ContainerInfo{}
+	for _, c := range idContainerInfo {
+		containers = append(containers, c)
+	}
+	sort.Sort(byName(containers))
+	for _, c := range containers {
+		if state[c] == unvisited {
+			visit(c)
+		}
+	}
+}
+
+// wakeOrder lists the containers to wake up for c, its group and all their
+// dependencies, dependencies first. c comes after the rest of its group.
+func wakeOrder(c *ContainerInfo) []*ContainerInfo {
+	order := []*ContainerInfo{}
+	seen := make(map[*ContainerInfo]bool)
+
+	var visit func(x *ContainerInfo)
+	visit = func(x *ContainerInfo) {
+		if seen[x] {
+			return
+		}
+		seen[x] = true
+		for _, d := range x.Dependencies {
+			visit(d)
+		}
+		order = append(order, x)
+	}
+
+	if c.Group != "" {
+		for _, m := range groupMembers[c.Group] {
+			if m != c {
+				visit(m)
+			}
+		}
+	}
+	visit(c)
+	return order
+}
+
+// neededElsewhere tells whether an awake container outside of the unit
+// depends on c, in which case c stays up.
+func neededElsewhere(c *ContainerInfo, unit []*ContainerInfo) bool {
+	inUnit := make(map[*ContainerInfo]bool)
+	for _, m := range unit {
+		inUnit[m] = true
+	}
+	for _, x := range containerInfos() {
+		if inUnit[x] || !x.awake() {
+			continue
+		}
+		for _, d := range x.Dependencies {
+			if d == c {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,12 +3,54 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
-	docker "github.com/fsouza/go-dockerclient"
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
 )
 
+type Address struct {
+	IP           string
+	IP6LinkLocal string
+	IP6Global    string
+	Port         string
+	HostPort     string
+	Proto        string
+	HostIP       string
+}
+
+type Volume struct {
+	Path      string
+	HostPath  string
+	ReadWrite bool
+}
+
+type DockerImage struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+func (i *DockerImage) String() string {
+	ret := i.Repository
+	if i.Registry != "" {
+		ret = i.Registry + "/" + i.Repository
+	}
+	if i.Tag != "" {
+		ret = ret + ":" + i.Tag
+	}
+	return ret
+}
+
+type SwarmNode struct {
+	ID      string
+	Name    string
+	Address Address
+}
+
 type DockerContainer struct {
 }
 type RuntimeContainer struct {
@@ -89,6 +131,72 @@ func parseHost(addr string) (string, string, error) {
 	return proto, fmt.Sprintf("%s:%d", host, port), nil
 }
 
+// docker endpoint settings, they default to the DOCKER_HOST, DOCKER_TLS_VERIFY
+// and DOCKER_CERT_PATH env variables like the docker cli
+var (
+	DockerHost      string
+	DockerTLSVerify bool
+	DockerCertPath  string
+)
+
+func defaultDockerHost() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	return "unix:///var/run/docker.sock"
+}
+
+func defaultDockerCertPath() string {
+	if path := os.Getenv("DOCKER_CERT_PATH"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".docker")
+}
+
+// newDockerClient connects to the docker daemon at host, a unix:// socket or
+// a tcp:// address. With tlsVerify the daemon's certificate is checked against
+// ca.pem and autosleep authenticates with cert.pem and key.pem from certPath.
+func newDockerClient(host string, tlsVerify bool, certPath string) (*docker.Client, error) {
+	proto, addr, err := parseHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case proto == "unix" && tlsVerify:
+		return nil, fmt.Errorf("TLS can't be used with the unix socket %s", addr)
+	case proto == "unix":
+		return docker.NewClient("unix://" + addr)
+	case proto == "tcp" && tlsVerify:
+		return docker.NewTLSClient("https://"+addr,
+			filepath.Join(certPath, "cert.pem"),
+			filepath.Join(certPath, "key.pem"),
+			filepath.Join(certPath, "ca.pem"))
+	case proto == "tcp":
+		return docker.NewClient("tcp://" + addr)
+	}
+	return nil, fmt.Errorf("unsupported docker host %s, expected unix:// or tcp://", host)
+}
+
+var (
+	clientMu sync.Mutex
+	client   *docker.Client // replaced by the events watcher, read it with dockerClient
+)
+
+// dockerClient returns the current docker client, it's never nil once
+// autosleep has started.
+func dockerClient() *docker.Client {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	return client
+}
+
+func setDockerClient(c *docker.Client) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	client = c
+}
+
 func splitDockerImage(img string) (string, string, string) {
 	index := 0
 	repository := img
@@ -126,62 +234,65 @@ func getContainers(client *docker.Client) ([]*RuntimeContainer, error) {
 			log.Printf("error inspecting container: %s: %s\n", apiContainer.ID, err)
 			continue
 		}
+		containers = append(containers, newRuntimeContainer(container))
+	}
+	return containers, nil
 
-		registry, repository, tag := splitDockerImage(container.Config.Image)
-		runtimeContainer := &RuntimeContainer{
-			ID: container.ID,
-			Image: DockerImage{
-				Registry:   registry,
-				Repository: repository,
-				Tag:        tag,
-			},
-			Name:         strings.TrimLeft(container.Name, "/"),
-			Hostname:     container.Config.Hostname,
-			Gateway:      container.NetworkSettings.Gateway,
-			Addresses:    []Address{},
-			Env:          make(map[string]string),
-			Volumes:      make(map[string]Volume),
-			Node:         SwarmNode{},
-			Labels:       make(map[string]string),
+}
+
+func newRuntimeContainer(container *docker.Container) *RuntimeContainer {
+	registry, repository, tag := splitDockerImage(container.Config.Image)
+	runtimeContainer := &RuntimeContainer{
+		ID: container.ID,
+		Image: DockerImage{
+			Registry:   registry,
+			Repository: repository,
+			Tag:        tag,
+		},
+		Name:         strings.TrimLeft(container.Name, "/"),
+		Hostname:     container.Config.Hostname,
+		Gateway:      container.NetworkSettings.Gateway,
+		Addresses:    []Address{},
+		Env:          make(map[string]string),
+		Volumes:      make(map[string]Volume),
+		Node:         SwarmNode{},
+		Labels:       make(map[string]string),
+		IP:           container.NetworkSettings.IPAddress,
+		IP6LinkLocal: container.NetworkSettings.LinkLocalIPv6Address,
+		IP6Global:    container.NetworkSettings.GlobalIPv6Address,
+	}
+	for k, v := range container.NetworkSettings.Ports {
+		address := Address{
 			IP:           container.NetworkSettings.IPAddress,
 			IP6LinkLocal: container.NetworkSettings.LinkLocalIPv6Address,
 			IP6Global:    container.NetworkSettings.GlobalIPv6Address,
+			Port:         k.Port(),
+			Proto:        k.Proto(),
 		}
-		for k, v := range container.NetworkSettings.Ports {
-			address := Address{
-				IP:           container.NetworkSettings.IPAddress,
-				IP6LinkLocal: container.NetworkSettings.LinkLocalIPv6Address,
-				IP6Global:    container.NetworkSettings.GlobalIPv6Address,
-				Port:         k.Port(),
-				Proto:        k.Proto(),
-			}
-			if len(v) > 0 {
-				address.HostPort = v[0].HostPort
-				address.HostIP = v[0].HostIP
-			}
-			runtimeContainer.Addresses = append(runtimeContainer.Addresses,
-				address)
-
+		if len(v) > 0 {
+			address.HostPort = v[0].HostPort
+			address.HostIP = v[0].HostIP
 		}
-		for k, v := range container.Volumes {
-			runtimeContainer.Volumes[k] = Volume{
-				Path:      k,
-				HostPath:  v,
-				ReadWrite: container.VolumesRW[k],
-			}
+		runtimeContainer.Addresses = append(runtimeContainer.Addresses,
+			address)
+
+	}
+	for k, v := range container.Volumes {
+		runtimeContainer.Volumes[k] = Volume{
+			Path:      k,
+			HostPath:  v,
+			ReadWrite: container.VolumesRW[k],
 		}
-		if container.Node != nil {
-			runtimeContainer.Node.ID = container.Node.ID
-			runtimeContainer.Node.Name = container.Node.Name
-			runtimeContainer.Node.Address = Address{
-				IP: container.Node.IP,
-			}
+	}
+	if container.Node != nil {
+		runtimeContainer.Node.ID = container.Node.ID
+		runtimeContainer.Node.Name = container.Node.Name
+		runtimeContainer.Node.Address = Address{
+			IP: container.Node.IP,
 		}
-
-		runtimeContainer.Env = splitKeyValueSlice(container.Config.Env)
-		runtimeContainer.Labels = container.Config.Labels
-		containers = append(containers, runtimeContainer)
 	}
-	return containers, nil
 
+	runtimeContainer.Env = splitKeyValueSlice(container.Config.Env)
+	runtimeContainer.Labels = container.Config.Labels
+	return runtimeContainer
 }
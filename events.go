@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+const (
+	WatchMinBackoff   = time.Second
+	WatchMaxBackoff   = time.Minute
+	WatchPingInterval = 30 * time.Second // a daemon that stops answering doesn't always close the stream
+)
+
+// watchDockerEvents keeps the containers' state in sync with docker events
+// until autosleep exits. When the connection is lost it reconnects with
+// exponential backoff, with a new client if the daemon doesn't answer, then
+// rediscovers the containers since events may have been missed meanwhile.
+func watchDockerEvents() {
+	backoff := WatchMinBackoff
+	connected := false // events were watched before, connecting again is a reconnect
+	for {
+		eventChan := make(chan *docker.APIEvents, 100)
+
+		c := dockerClient()
+		err := countDockerError(c.Ping())
+		if err != nil {
+			// the old client may be stuck on a dead connection, the client is never nil
+			if nc, cerr := newDockerClient(DockerHost, DockerTLSVerify, DockerCertPath); cerr == nil {
+				setDockerClient(nc)
+			}
+		} else {
+			err = countDockerError(c.AddEventListener(eventChan))
+		}
+		if err != nil {
+			log.Errorf("Unable to watch docker events, retrying in %s: %s", backoff, err)
+			backoff = sleepBackoff(backoff)
+			continue
+		}
+
+		if connected {
+			atomic.AddInt64(&watcherReconnects, 1)
+			log.Println("Reconnected to docker, rediscovering the containers")
+			getAllDockerContainers()
+			updateTCPListeners()
+		}
+		connected = true
+		log.Println("Watching docker events")
+
+		since := time.Now()
+		watchEvents(c, eventChan)
+		stopWatching(c, eventChan)
+
+		// a connection dropped right away counts as a failed attempt
+		if time.Now().Sub(since) >= WatchMaxBackoff {
+			backoff = WatchMinBackoff
+		}
+		log.Warningf("Lost the connection to docker events, reconnecting in %s", backoff)
+		backoff = sleepBackoff(backoff)
+	}
+}
+
+// sleepBackoff sleeps for backoff and returns the next one.
+func sleepBackoff(backoff time.Duration) time.Duration {
+	time.Sleep(backoff)
+	backoff *= 2
+	if backoff > WatchMaxBackoff {
+		backoff = WatchMaxBackoff
+	}
+	return backoff
+}
+
+// watchEvents handles the events until the client closes the channel, which
+// it does when the stream ends, or the daemon stops answering pings.
+func watchEvents(c *docker.Client, eventChan chan *docker.APIEvents) {
+	ping := time.NewTicker(WatchPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case event, ok := <-eventChan:
+			if !ok || event == nil || event == docker.EOFEvent {
+				return
+			}
+			handleDockerEvent(event)
+		case <-ping.C:
+			if err := countDockerError(c.Ping()); err != nil {
+				log.Errorf("Unable to ping docker daemon: %s", err)
+				return
+			}
+		}
+	}
+}
+
+// stopWatching removes the listener, draining it meanwhile since the client
+// waits for pending events to be delivered.
+func stopWatching(c *docker.Client, eventChan chan *docker.APIEvents) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case _, ok := <-eventChan:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	c.RemoveEventListener(eventChan)
+	close(done)
+}
+
+func handleDockerEvent(event *docker.APIEvents) {
+	containerInfo := containerByID(event.ID)
+	if containerInfo == nil {
+		return
+	}
+	log.Printf("Received event %s for container %s", event.Status, event.ID[:12])
+
+	switch event.Status {
+	case "start":
+		containerInfo.mu.Lock()
+		containerInfo.Running = true
+		containerInfo.SleepReason = ""
+		containerInfo.mu.Unlock()
+		if container, err := dockerClient().InspectContainer(event.ID); countDockerError(err) != nil {
+			log.Errorln(err)
+		} else {
+			containerInfo.mu.Lock()
+			if !container.State.StartedAt.Equal(containerInfo.StartedAt) {
+				// started by someone else, only trusted right away without a probe
+				containerInfo.StartedAt = container.State.StartedAt
+				containerInfo.Ready = containerInfo.Probe == nil
+			}
+			containerInfo.mu.Unlock()
+			updateTarget(containerInfo, container)
+		}
+	case "die", "stop":
+		containerInfo.mu.Lock()
+		containerInfo.Running = false
+		containerInfo.Paused = false
+		containerInfo.Ready = false
+		containerInfo.mu.Unlock()
+	case "pause":
+		containerInfo.mu.Lock()
+		containerInfo.Paused = true
+		containerInfo.mu.Unlock()
+	case "unpause":
+		containerInfo.mu.Lock()
+		containerInfo.Paused = false
+		containerInfo.SleepReason = ""
+		containerInfo.mu.Unlock()
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// GroupSetting puts containers in a group that sleeps and wakes up together,
+// containers started by docker-compose are grouped by project unless they set it.
+const (
+	GroupSetting        = "group"
+	ComposeProjectLabel = "com.docker.compose.project"
+)
+
+var groupMembers map[string][]*ContainerInfo // maps group name to its containers
+
+func containerGroup(container *docker.Container) string {
+	if group := containerSetting(container, GroupSetting); group != "" {
+		return group
+	}
+	return container.Config.Labels[ComposeProjectLabel]
+}
+
+// entryPoint tells whether requests or connections reach the container
+// directly, only entry points keep their group awake.
+func (c *ContainerInfo) entryPoint() bool {
+	return len(c.Hosts) > 0 || len(c.TCPRoutes) > 0
+}
+
+// members returns the containers woken up and put to sleep together with c,
+// c included: its group and their dependencies, in wake up order.
+func (c *ContainerInfo) members() []*ContainerInfo {
+	if c.WakeOrder == nil {
+		return []*ContainerInfo{c}
+	}
+	return c.WakeOrder
+}
+
+// unitKey identifies the set of containers put to sleep together.
+func (c *ContainerInfo) unitKey() string {
+	if c.Group != "" {
+		return c.Group
+	}
+	return c.ID
+}
+
+// ready tells whether the container and the rest of its group are awake and
+// no wake up is in progress.
+func (c *ContainerInfo) ready() bool {
+	for _, m := range c.members() {
+		if !m.awake() || waking(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupWaking tells whether any container of the group is waking up.
+func (c *ContainerInfo) groupWaking() bool {
+	for _, m := range c.members() {
+		if waking(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// addGroupMembers keeps the grouped containers that have an entry point in
+// their group. Groups without entry points are left alone.
+func addGroupMembers(grouped []*ContainerInfo) {
+	entryGroups := make(map[string]bool)
+	for _, c := range idContainerInfo {
+		if c.Group != "" {
+			entryGroups[c.Group] = true
+		}
+	}
+	for _, c := range grouped {
+		if entryGroups[c.Group] {
+			idContainerInfo[c.ID] = c
+		}
+	}
+}
+
+// indexGroups indexes the groups and works out the wake up order of every container.
+func indexGroups() {
+	groupMembers = make(map[string][]*ContainerInfo)
+	for _, c := range idContainerInfo {
+		if c.Group != "" {
+			groupMembers[c.Group] = append(groupMembers[c.Group], c)
+		}
+	}
+	for _, members := range groupMembers {
+		sort.Sort(byName(members))
+	}
+	for _, c := range idContainerInfo {
+		c.WakeOrder = wakeOrder(c)
+	}
+}
+
+type sleepUnit struct {
+	key     string
+	members []*ContainerInfo // in wake up order
+}
+
+// sleepUnits splits the containers into the sets that are put to sleep
+// together: a group or a single container, along with their dependencies.
+func sleepUnits() []sleepUnit {
+	units := []sleepUnit{}
+	seen := make(map[string]bool)
+	for _, c := range containerInfos() {
+		if !c.entryPoint() || seen[c.unitKey()] {
+			continue
+		}
+		seen[c.unitKey()] = true
+		units = append(units, sleepUnit{key: c.unitKey(), members: c.members()})
+	}
+	return units
+}
+
+// idleFor returns for how long all the entry points of the unit have been
+// idle, false if any of them is busy or must not be put to sleep. Dependencies
+// that are entry points of another unit don't count.
+func idleFor(unit sleepUnit) (time.Duration, bool) {
+	var idle time.Duration
+	entryPoints := 0
+	for _, c := range unit.members {
+		if !c.entryPoint() || c.unitKey() != unit.key {
+			continue
+		}
+		entryPoints++
+		if !c.AutoSleep {
+			return 0, false
+		}
+		if _, ok := c.awakeWindow(time.Now()); ok {
+			return 0, false
+		}
+		if atomic.LoadInt32(&c.OpenConns) > 0 {
+			// open connections count as activity until they're closed
+			return 0, false
+		}
+		d := time.Now().Sub(c.lastAccess())
+		if d <= c.idleTimeout() {
+			return 0, false
+		}
+		if entryPoints == 1 || d < idle {
+			idle = d
+		}
+	}
+	return idle, entryPoints > 0
+}
+
+type byName []*ContainerInfo
+
+func (c byName) Len() int           { return len(c) }
+func (c byName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byName) Less(i, j int) bool { return c[i].Name < c[j].Name }
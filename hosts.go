@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// parseVirtualHosts splits VIRTUAL_HOST the way docker-gen does, e.g.
+// VIRTUAL_HOST=foo.example.com,*.foo.example.com,www.foo.*
+func parseVirtualHosts(vHost string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(vHost, ",") {
+		host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// normalizeHost lower cases the Host header and drops its port.
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// lookupHost finds the container for the Host header like nginx matches
+// server_name: the exact name first, then the longest leading wildcard
+// (*.example.com), then the longest trailing wildcard (www.example.*).
+func lookupHost(host string) *ContainerInfo {
+	host = normalizeHost(host)
+
+	containersMu.RLock()
+	defer containersMu.RUnlock()
+
+	if c, ok := hostContainerInfo[host]; ok {
+		return c
+	}
+	for i := strings.Index(host, "."); i >= 0; {
+		if c, ok := hostContainerInfo["*"+host[i:]]; ok {
+			return c
+		}
+		next := strings.Index(host[i+1:], ".")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	for i := strings.LastIndex(host, "."); i > 0; i = strings.LastIndex(host[:i], ".") {
+		if c, ok := hostContainerInfo[host[:i+1]+"*"]; ok {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestLookupHost(t *testing.T) {
+	saved := hostContainerInfo
+	defer func() { hostContainerInfo = saved }()
+
+	hostContainerInfo = make(map[string]*ContainerInfo)
+	for _, host := range []string{
+		"example.com", "*.example.com", "*.api.example.com", "www.example.*", "www.example.co.*", "api.example.com",
+	} {
+		hostContainerInfo[host] = &ContainerInfo{Name: host}
+	}
+
+	tests := []struct {
+		host string
+		want string // matched entry, empty for none
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com:8080", "example.com"},
+		{"example.com.", "example.com"},
+		{"api.example.com", "api.example.com"}, // exact name before wildcards
+		{"foo.example.com", "*.example.com"},
+		{"a.b.example.com", "*.example.com"},
+		{"v1.api.example.com", "*.api.example.com"}, // longest leading wildcard
+		{"www.example.com", "*.example.com"},        // leading wildcards before trailing ones
+		{"www.example.org", "www.example.*"},
+		{"www.example.co.uk", "www.example.co.*"}, // longest trailing wildcard
+		{"[::1]:80", ""},
+		{"example.org", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if c := lookupHost(test.host); c != nil {
+			got = c.Name
+		}
+		if got != test.want {
+			t.Errorf("lookupHost(%q) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
+
+func TestParseVirtualHosts(t *testing.T) {
+	tests := []struct {
+		vHost string
+		want  []string
+	}{
+		{"", []string{}},
+		{"Foo.Example.com", []string{"foo.example.com"}},
+		{"foo.example.com, *.foo.example.com ,www.foo.*", []string{"foo.example.com", "*.foo.example.com", "www.foo.*"}},
+		{"foo.example.com.,,", []string{"foo.example.com"}},
+	}
+	for _, test := range tests {
+		got := parseVirtualHosts(test.vHost)
+		if len(got) != len(test.want) {
+			t.Errorf("parseVirtualHosts(%q) = %q, want %q", test.vHost, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("parseVirtualHosts(%q) = %q, want %q", test.vHost, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsAddr is an extra address /metrics is served on, e.g. :9180, for
+// Prometheus to scrape. /metrics is always served by the admin API too.
+var MetricsAddr string
+
+// ColdStartBuckets are the upper bounds, in seconds, of the cold start histogram.
+var ColdStartBuckets = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+
+type requestKey struct {
+	host string
+	cold bool
+}
+
+var (
+	metricsMu     sync.Mutex
+	wakeCounts    = make(map[string]int64) // maps host to its wake ups
+	wakeFailures  = make(map[string]int64) // maps host to its failed wake ups
+	requestCounts = make(map[requestKey]int64)
+	coldStarts    = newHistogram(ColdStartBuckets)
+
+	dockerErrors      int64 // accessed atomically
+	watcherReconnects int64 // accessed atomically
+)
+
+type histogram struct {
+	bounds []float64
+	counts []int64 // per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// countWake counts the wake up under the container's host, members and
+// dependencies serving no host of their own aren't counted.
+func countWake(c *ContainerInfo, err error) {
+	if len(c.Hosts) == 0 {
+		return
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	wakeCounts[c.Hosts[0]]++
+	if err != nil {
+		wakeFailures[c.Hosts[0]]++
+	}
+}
+
+func countRequest(c *ContainerInfo, cold bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	requestCounts[requestKey{c.predictionKey(), cold}]++
+}
+
+func observeColdStart(d time.Duration) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	coldStarts.observe(d.Seconds())
+}
+
+// countDockerError counts the error if there's one and returns it, to wrap Docker API calls.
+func countDockerError(err error) error {
+	if err != nil {
+		atomic.AddInt64(&dockerErrors, 1)
+	}
+	return err
+}
+
+// serveMetrics writes the metrics in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+func writeMetrics(w io.Writer) {
+	awake, sleeping := 0, 0
+	for _, c := range containerInfos() {
+		if c.awake() {
+			awake++
+		} else {
+			sleeping++
+		}
+	}
+
+	prewarms := prewarmStats()
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	writeHeader(w, "autosleep_wakes_total", "counter", "Container wake ups per host.")
+	for _, host := range sortedKeys(wakeCounts) {
+		fmt.Fprintf(w, "autosleep_wakes_total{host=\"%s\"} %d\n", escapeLabel(host), wakeCounts[host])
+	}
+	writeHeader(w, "autosleep_wake_failures_total", "counter", "Failed container wake ups per host.")
+	for _, host := range sortedKeys(wakeFailures) {
+		fmt.Fprintf(w, "autosleep_wake_failures_total{host=\"%s\"} %d\n", escapeLabel(host), wakeFailures[host])
+	}
+
+	writeHeader(w, "autosleep_requests_total", "counter", "Requests per host, served warm or after a cold start.")
+	keys := []requestKey{}
+	for k := range requestCounts {
+		keys = append(keys, k)
+	}
+	sort.Sort(byRequestKey(keys))
+	for _, k := range keys {
+		start := "warm"
+		if k.cold {
+			start = "cold"
+		}
+		fmt.Fprintf(w, "autosleep_requests_total{host=\"%s\",start=\"%s\"} %d\n", escapeLabel(k.host), start, requestCounts[k])
+	}
+
+	writeHeader(w, "autosleep_cold_start_seconds", "histogram", "Time from the first request waiting for a container to it being ready.")
+	cumulative := int64(0)
+	for i, bound := range coldStarts.bounds {
+		cumulative += coldStarts.counts[i]
+		fmt.Fprintf(w, "autosleep_cold_start_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "autosleep_cold_start_seconds_bucket{le=\"+Inf\"} %d\n", coldStarts.count)
+	fmt.Fprintf(w, "autosleep_cold_start_seconds_sum %g\n", coldStarts.sum)
+	fmt.Fprintf(w, "autosleep_cold_start_seconds_count %d\n", coldStarts.count)
+
+	writeHeader(w, "autosleep_containers", "gauge", "Managed containers, awake or sleeping.")
+	fmt.Fprintf(w, "autosleep_containers{state=\"awake\"} %d\n", awake)
+	fmt.Fprintf(w, "autosleep_containers{state=\"sleeping\"} %d\n", sleeping)
+
+	writeHeader(w, "autosleep_prewarms_total", "counter", "Pre-warms per host.")
+	for _, p := range prewarms {
+		fmt.Fprintf(w, "autosleep_prewarms_total{host=\"%s\"} %d\n", escapeLabel(p.host), p.Prewarms)
+	}
+	writeHeader(w, "autosleep_prewarm_cold_starts_avoided_total", "counter", "Cold starts avoided by pre-warming per host.")
+	for _, p := range prewarms {
+		fmt.Fprintf(w, "autosleep_prewarm_cold_starts_avoided_total{host=\"%s\"} %d\n", escapeLabel(p.host), p.ColdStartsAvoided)
+	}
+	writeHeader(w, "autosleep_prewarm_extra_awake_seconds_total", "counter", "Awake time spent on pre-warms per host.")
+	for _, p := range prewarms {
+		fmt.Fprintf(w, "autosleep_prewarm_extra_awake_seconds_total{host=\"%s\"} %g\n", escapeLabel(p.host), p.ExtraAwake.Seconds())
+	}
+
+	writeHeader(w, "autosleep_docker_errors_total", "counter", "Failed Docker API calls.")
+	fmt.Fprintf(w, "autosleep_docker_errors_total %d\n", atomic.LoadInt64(&dockerErrors))
+
+	writeHeader(w, "autosleep_watcher_reconnects_total", "counter", "Reconnections of the Docker events watcher.")
+	fmt.Fprintf(w, "autosleep_watcher_reconnects_total %d\n", atomic.LoadInt64(&watcherReconnects))
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type byRequestKey []requestKey
+
+func (k byRequestKey) Len() int      { return len(k) }
+func (k byRequestKey) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k byRequestKey) Less(i, j int) bool {
+	if k[i].host != k[j].host {
+		return k[i].host < k[j].host
+	}
+	return !k[i].cold && k[j].cold
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// sleep policy settings, read from the autosleep.<key> label or the
+// AUTOSLEEP_<KEY> env variable of the container, e.g.
+//
+//	docker run -l autosleep.idle=15m ...
+//	docker run -e AUTOSLEEP_ENABLED=false ...
+const (
+	IdleSetting    = "idle"
+	EnabledSetting = "enabled"
+	ModeSetting    = "mode"
+)
+
+// sleep modes, pause freezes the container keeping its memory so it wakes up
+// near instantly
+const (
+	SleepModeStop  = "stop"
+	SleepModePause = "pause"
+)
+
+// containerSetting returns the container's autosleep.<key> label, falling back
+// to its AUTOSLEEP_<KEY> env variable, then to the config file's settings for its hosts.
+func containerSetting(container *docker.Container, key string) string {
+	if v, ok := container.Config.Labels["autosleep."+key]; ok {
+		return v
+	}
+	env := splitKeyValueSlice(container.Config.Env)
+	envKey := "AUTOSLEEP_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+	if v, ok := env[envKey]; ok {
+		return v
+	}
+	return hostSetting(parseVirtualHosts(env["VIRTUAL_HOST"]), key)
+}
+
+// parseSleepPolicy sets the container's idle timeout, 0 meaning -autosleepin,
+// whether it may be put to sleep at all and how. Invalid settings are left at
+// their defaults.
+func parseSleepPolicy(containerInfo *ContainerInfo, container *docker.Container) error {
+	containerInfo.AutoSleep = true
+	containerInfo.SleepMode = SleepModeStop
+	containerInfo.IdleTimeout = 0
+	containerInfo.Schedules = nil
+	containerInfo.QueueDepth = 0
+	containerInfo.QueueWait = 0
+
+	var errs []string
+	if v := containerSetting(container, IdleSetting); v != "" {
+		d, err := parseSeconds(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("invalid %s=%s, expected a duration like 15m", IdleSetting, v))
+		} else {
+			containerInfo.IdleTimeout = d
+		}
+	}
+
+	if v := containerSetting(container, EnabledSetting); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s=%s, expected true or false", EnabledSetting, v))
+		} else {
+			containerInfo.AutoSleep = b
+		}
+	}
+
+	if v := containerSetting(container, ModeSetting); v != "" {
+		if v != SleepModeStop && v != SleepModePause {
+			errs = append(errs, fmt.Sprintf("invalid %s=%s, expected %s or %s", ModeSetting, v, SleepModeStop, SleepModePause))
+		} else {
+			containerInfo.SleepMode = v
+		}
+	}
+
+	if v := containerSetting(container, AwakeSetting); v != "" {
+		schedules, err := parseSchedules(v)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			containerInfo.Schedules = schedules
+		}
+	}
+
+	if busy, err := parseBusyThresholds(container); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		containerInfo.Busy = busy
+	}
+
+	if rules, err := parseTrafficRules(container); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		containerInfo.Ignore = rules
+	}
+
+	if err := parseQueueSettings(containerInfo, container); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// parseSeconds parses a duration like 15m, a plain number is taken as seconds
+// like -autosleepin.
+func parseSeconds(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// idleTimeout is how long the container may stay idle before it's put to sleep.
+func (c *ContainerInfo) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return time.Duration(config().AutoSleepIn) * time.Second
+}
+
+// sleepCheckInterval is how often stopInactiveContainers runs, a third of the
+// shortest idle timeout.
+func sleepCheckInterval() time.Duration {
+	shortest := time.Duration(config().AutoSleepIn) * time.Second
+	for _, c := range containerInfos() {
+		if c.entryPoint() && c.AutoSleep && c.idleTimeout() < shortest {
+			shortest = c.idleTimeout()
+		}
+	}
+	if shortest < 3*time.Second {
+		return time.Second
+	}
+	return shortest / 3
+}
+
+// awake tells whether the container is running, not paused and ready.
+func (c *ContainerInfo) awake() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Running && !c.Paused && c.Ready
+}
+
+func (c *ContainerInfo) lastAccess() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.LastAccess
+}
+
+func (c *ContainerInfo) startedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.StartedAt
+}
+
+// touch records an access at t, resetting the idle timer.
+func (c *ContainerInfo) touch(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.LastAccess = t
+}
+
+func (c *ContainerInfo) wakeError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.WakeError
+}
+
+func (c *ContainerInfo) setWakeError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.WakeError = err
+}
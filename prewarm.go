@@ -0,0 +1,217 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// access times are counted in 15 minute slots over a week, a slot's confidence
+// is the share of past weeks that had traffic in it
+const (
+	SlotLength    = 15 * time.Minute
+	SlotsPerWeek  = 7 * 24 * 4
+	Week          = 7 * 24 * time.Hour
+	PrewarmMinObs = 2 // weeks a slot must have been observed before it's trusted
+)
+
+var (
+	Prewarm bool
+
+	predictionsMu sync.Mutex
+	predictions   = make(map[string]*AccessHistogram) // maps host to its access histogram
+)
+
+type AccessHistogram struct {
+	Since time.Time
+	Hits  []int   // weeks with traffic, per slot
+	Last  []int64 // start of the last occurrence counted, per slot
+
+	Prewarms          int
+	ColdStartsAvoided int
+	ExtraAwake        time.Duration
+}
+
+func newAccessHistogram(since time.Time) *AccessHistogram {
+	return &AccessHistogram{
+		Since: since,
+		Hits:  make([]int, SlotsPerWeek),
+		Last:  make([]int64, SlotsPerWeek),
+	}
+}
+
+func slotOf(t time.Time) int {
+	t = t.Local()
+	return int(t.Weekday())*24*4 + t.Hour()*4 + t.Minute()/15
+}
+
+// occurrences counts how many times the slot came around since the histogram started.
+func (h *AccessHistogram) occurrences(slot int, now time.Time) int {
+	since := h.Since.Local()
+	weekStart := time.Date(since.Year(), since.Month(), since.Day()-int(since.Weekday()), 0, 0, 0, 0, time.Local)
+	first := weekStart.Add(time.Duration(slot) * SlotLength)
+	if first.Before(since.Truncate(SlotLength)) {
+		first = first.Add(Week)
+	}
+	if first.After(now) {
+		return 0
+	}
+	return 1 + int(now.Sub(first)/Week)
+}
+
+// confidence is the share of the past weeks with traffic in the slot of t.
+func (h *AccessHistogram) confidence(t time.Time, now time.Time) float64 {
+	slot := slotOf(t)
+	n := h.occurrences(slot, now)
+	if n < PrewarmMinObs {
+		return 0
+	}
+	return float64(h.Hits[slot]) / float64(n)
+}
+
+// predictionKey is the host the container's traffic is recorded under.
+func (c *ContainerInfo) predictionKey() string {
+	if len(c.Hosts) > 0 {
+		return c.Hosts[0]
+	}
+	return containerName(c)
+}
+
+// recordAccess counts the access in the container's histogram and settles a
+// pending pre-warm: the cold start it avoided, if the container was ready for
+// the access, and the awake time it cost.
+func recordAccess(c *ContainerInfo, t time.Time) {
+	ready := c.ready()
+
+	predictionsMu.Lock()
+	defer predictionsMu.Unlock()
+
+	h, ok := predictions[c.predictionKey()]
+	if !ok {
+		h = newAccessHistogram(t)
+		predictions[c.predictionKey()] = h
+	}
+	slot := slotOf(t)
+	occurrence := t.Truncate(SlotLength).Unix()
+	if h.Last[slot] != occurrence {
+		h.Last[slot] = occurrence
+		h.Hits[slot]++
+	}
+
+	c.mu.Lock()
+	prewarmedAt := c.PrewarmedAt
+	c.PrewarmedAt = time.Time{}
+	c.mu.Unlock()
+	if prewarmedAt.IsZero() {
+		return
+	}
+	h.ExtraAwake += t.Sub(prewarmedAt)
+	if ready {
+		h.ColdStartsAvoided++
+		log.Printf("pre-warming avoided a cold start for %s, %d so far costing %s of extra awake time",
+			c.predictionKey(), h.ColdStartsAvoided, h.ExtraAwake)
+	}
+}
+
+// prewarmWasted settles a pre-warm nobody used when the container goes back to sleep.
+func prewarmWasted(c *ContainerInfo, t time.Time) {
+	predictionsMu.Lock()
+	defer predictionsMu.Unlock()
+
+	c.mu.Lock()
+	prewarmedAt := c.PrewarmedAt
+	c.PrewarmedAt = time.Time{}
+	c.mu.Unlock()
+	if prewarmedAt.IsZero() {
+		return
+	}
+	if h, ok := predictions[c.predictionKey()]; ok {
+		h.ExtraAwake += t.Sub(prewarmedAt)
+		log.Printf("pre-warming %s wasn't used, %s of extra awake time so far", c.predictionKey(), h.ExtraAwake)
+	}
+}
+
+type prewarmStat struct {
+	host              string
+	Prewarms          int
+	ColdStartsAvoided int
+	ExtraAwake        time.Duration
+}
+
+// prewarmStats returns the pre-warming stats of every host, sorted by host.
+func prewarmStats() []prewarmStat {
+	predictionsMu.Lock()
+	defer predictionsMu.Unlock()
+
+	stats := []prewarmStat{}
+	for host, h := range predictions {
+		stats = append(stats, prewarmStat{host, h.Prewarms, h.ColdStartsAvoided, h.ExtraAwake})
+	}
+	sort.Sort(byPrewarmHost(stats))
+	return stats
+}
+
+type byPrewarmHost []prewarmStat
+
+func (s byPrewarmHost) Len() int           { return len(s) }
+func (s byPrewarmHost) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPrewarmHost) Less(i, j int) bool { return s[i].host < s[j].host }
+
+// watchPredictions wakes up sleeping containers -prewarm-lead before a slot they
+// usually get traffic in.
+func watchPredictions() {
+	for {
+		now := time.Now()
+		cfg := config()
+		expected := now.Add(cfg.PrewarmLead)
+		occurrence := expected.Truncate(SlotLength).Unix()
+
+		for _, c := range containerInfos() {
+			c.mu.Lock()
+			slotDone := c.PrewarmSlot == occurrence
+			c.mu.Unlock()
+			if !c.entryPoint() || slotDone || c.ready() {
+				continue
+			}
+
+			predictionsMu.Lock()
+			h, ok := predictions[c.predictionKey()]
+			confidence := 0.0
+			if ok {
+				confidence = h.confidence(expected, now)
+			}
+			prewarm := ok && confidence >= cfg.PrewarmConfidence
+			if prewarm {
+				h.Prewarms++
+				c.mu.Lock()
+				c.PrewarmSlot = occurrence
+				c.mu.Unlock()
+			}
+			predictionsMu.Unlock()
+
+			if prewarm {
+				log.Printf("pre-warming %s, expecting traffic at %s with %.0f%% confidence",
+					c.predictionKey(), expected.Truncate(SlotLength).Format("Mon 15:04"), confidence*100)
+				// a full idle period starts now, it's the price of the pre-warm
+				c.touch(now)
+				go prewarmContainer(c, now)
+			}
+		}
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(time.Now()))
+	}
+}
+
+// prewarmContainer wakes up the container and marks it pre-warmed once it's
+// ready, unless it was accessed meanwhile: that access paid for the cold start.
+func prewarmContainer(c *ContainerInfo, now time.Time) {
+	if err := wakeContainer(c); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.LastAccess.Equal(now) {
+		c.PrewarmedAt = time.Now()
+	}
+}
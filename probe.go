@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// labels used to configure the readiness probe of a container, e.g.
+//
+//	docker run -l autosleep.probe=http -l autosleep.probe.path=/health ...
+//	docker run -l autosleep.probe=tcp -l autosleep.probe.port=5432 ...
+const (
+	ProbeLabel        = "autosleep.probe"
+	ProbePathLabel    = "autosleep.probe.path"
+	ProbeStatusLabel  = "autosleep.probe.status"
+	ProbePortLabel    = "autosleep.probe.port"
+	ProbeTimeoutLabel = "autosleep.probe.timeout"
+)
+
+const (
+	ProbeTimeout      = 60 // default deadline in seconds
+	ProbeCheckTimeout = 2 * time.Second
+	ProbeMinBackoff   = 100 * time.Millisecond
+	ProbeMaxBackoff   = 2 * time.Second
+)
+
+type Probe struct {
+	Kind    string // "http" or "tcp"
+	Path    string
+	Status  int
+	Port    string
+	Timeout time.Duration
+}
+
+// parseProbe reads the probe labels, it returns nil if the container has no probe configured.
+func parseProbe(labels map[string]string) (*Probe, error) {
+	kind := labels[ProbeLabel]
+	if kind == "" {
+		return nil, nil
+	}
+	if kind != "http" && kind != "tcp" {
+		return nil, fmt.Errorf("invalid %s=%s, must be http or tcp", ProbeLabel, kind)
+	}
+
+	probe := &Probe{
+		Kind:    kind,
+		Path:    "/",
+		Status:  http.StatusOK,
+		Port:    labels[ProbePortLabel],
+		Timeout: ProbeTimeout * time.Second,
+	}
+
+	if path := labels[ProbePathLabel]; path != "" {
+		probe.Path = path
+	}
+	if status := labels[ProbeStatusLabel]; status != "" {
+		s, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", ProbeStatusLabel, status, err)
+		}
+		probe.Status = s
+	}
+	if timeout := labels[ProbeTimeoutLabel]; timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", ProbeTimeoutLabel, timeout, err)
+		}
+		probe.Timeout = d
+	}
+	return probe, nil
+}
+
+// address returns the ip:port the probe connects to. The container is inspected
+// after it's started since its IP may change on every start.
+func (p *Probe) address(container *docker.Container) (string, error) {
+	port := p.Port
+	if port == "" {
+		// the port requests are sent to
+		var err error
+		if port, err = containerPort(container); err != nil {
+			return "", fmt.Errorf("%s, set %s", err, ProbePortLabel)
+		}
+	}
+
+	ip := container.NetworkSettings.IPAddress
+	if ip == "" {
+		// host networking
+		ip = "127.0.0.1"
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+func (p *Probe) check(addr string) error {
+	if p.Kind == "tcp" {
+		conn, err := net.DialTimeout("tcp", addr, ProbeCheckTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	c := &http.Client{Timeout: ProbeCheckTimeout}
+	resp, err := c.Get("http://" + addr + p.Path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != p.Status {
+		return fmt.Errorf("GET %s returned %d, expected %d", p.Path, resp.StatusCode, p.Status)
+	}
+	return nil
+}
+
+// waitReady polls the container's probe with backoff until it passes or the
+// probe's deadline expires.
+func waitReady(containerInfo *ContainerInfo, container *docker.Container) error {
+	p := containerInfo.Probe
+
+	addr, err := p.address(container)
+	if err != nil {
+		return err
+	}
+
+	err = retry(p.Timeout, func() error { return p.check(addr) })
+	if err != nil {
+		return fmt.Errorf("container %s %s not ready after %s, %s probe on %s: %s",
+			containerInfo.ID[:12], containerInfo.Name, p.Timeout, p.Kind, addr, err)
+	}
+	return nil
+}
+
+// retry calls f with backoff until it succeeds or timeout expires, it returns f's last error.
+func retry(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	backoff := ProbeMinBackoff
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		log.Debugf("retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > ProbeMaxBackoff {
+			backoff = ProbeMaxBackoff
+		}
+	}
+}
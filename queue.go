@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// settings overriding -queue-depth and -queue-wait for the container
+const (
+	QueueDepthSetting = "queue.depth"
+	QueueWaitSetting  = "queue.wait"
+)
+
+// QueueRetryAfter is the Retry-After sent when a request can't wait for the container.
+const QueueRetryAfter = 5
+
+var (
+	QueueWait time.Duration
+
+	errQueueFull    = errors.New("too many requests waiting for the container to wake up")
+	errQueueTimeout = errors.New("timed out waiting for the container to wake up")
+)
+
+// wakeQueue holds the requests waiting for a container to wake up, they're
+// released in arrival order once it's ready.
+type wakeQueue struct {
+	mu      sync.Mutex
+	waiters []chan error
+	since   time.Time // when the first request started waiting, for the cold start metric
+}
+
+// mark notes that a request is waiting for the wake up.
+func (q *wakeQueue) mark() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.since.IsZero() {
+		q.since = time.Now()
+	}
+}
+
+func (q *wakeQueue) enqueue(depth int) (chan error, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) >= depth {
+		return nil, false
+	}
+	ch := make(chan error, 1)
+	q.waiters = append(q.waiters, ch)
+	if q.since.IsZero() {
+		q.since = time.Now()
+	}
+	return ch, true
+}
+
+func (q *wakeQueue) remove(ch chan error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, waiter := range q.waiters {
+		if waiter == ch {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// release hands the result of the wake up to every waiting request, first come first served.
+func (q *wakeQueue) release(err error) {
+	q.mu.Lock()
+	waiters := q.waiters
+	since := q.since
+	q.waiters = nil
+	q.since = time.Time{}
+	q.mu.Unlock()
+
+	if err == nil && !since.IsZero() {
+		observeColdStart(time.Now().Sub(since))
+	}
+	for _, ch := range waiters {
+		ch <- err
+	}
+}
+
+func parseQueueSettings(containerInfo *ContainerInfo, container *docker.Container) error {
+	if v := containerSetting(container, QueueDepthSetting); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s=%s, expected a positive number", QueueDepthSetting, v)
+		}
+		containerInfo.QueueDepth = n
+	}
+	if v := containerSetting(container, QueueWaitSetting); v != "" {
+		d, err := parseSeconds(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s=%s, expected a duration like 20s", QueueWaitSetting, v)
+		}
+		containerInfo.QueueWait = d
+	}
+	return nil
+}
+
+// queueWait is how long requests wait for the container, it can't be more
+// than -queue-wait, the server's write timeout is based on it.
+func (c *ContainerInfo) queueWait() time.Duration {
+	if c.QueueWait > 0 && c.QueueWait < QueueWait {
+		return c.QueueWait
+	}
+	return QueueWait
+}
+
+func (c *ContainerInfo) queueDepth() int {
+	if c.QueueDepth > 0 {
+		return c.QueueDepth
+	}
+	return config().QueueDepth
+}
+
+// waitForWake queues the request until the container is awake, it fails right
+// away if the queue is full, or once the request waited for too long.
+func waitForWake(containerInfo *ContainerInfo) error {
+	ch, ok := containerInfo.queue.enqueue(containerInfo.queueDepth())
+	if !ok {
+		return errQueueFull
+	}
+
+	// concurrent wake ups are shared, whichever finishes releases the queue
+	go wakeAndRelease(containerInfo)
+
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(containerInfo.queueWait()):
+		containerInfo.queue.remove(ch)
+		return errQueueTimeout
+	}
+}
+
+func wakeAndRelease(containerInfo *ContainerInfo) {
+	containerInfo.queue.release(wakeContainer(containerInfo))
+}
+
+// wakeInBackground wakes up the container for a request that's answered
+// without waiting, like the waking up page.
+func wakeInBackground(containerInfo *ContainerInfo) {
+	containerInfo.queue.mark()
+	go wakeAndRelease(containerInfo)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// settings picking out the requests that don't count as activity, like health
+// checks and crawlers, and what happens to them, e.g.
+//
+//	docker run -l autosleep.ignore.path='^/health' -l autosleep.ignore.agent='(?i)bot|pingdom' ...
+const (
+	IgnorePathSetting       = "ignore.path"        // regexp on the path
+	IgnoreAgentSetting      = "ignore.agent"       // regexp on the User-Agent
+	IgnoreCIDRSetting       = "ignore.cidr"        // client networks, separated by commas
+	IgnoreTouchSetting      = "ignore.touch"       // true to refresh LastAccess anyway
+	IgnoreWakeSetting       = "ignore.wake"        // true to wake up the container anyway
+	IgnoreStatusSetting     = "ignore.status"      // status for requests not allowed to wake the container
+	IgnoreRetryAfterSetting = "ignore.retry_after" // Retry-After seconds for those requests
+	IgnoreBodySetting       = "ignore.body"        // body for those requests
+)
+
+type TrafficRules struct {
+	Path       *regexp.Regexp
+	Agent      *regexp.Regexp
+	Networks   []*net.IPNet
+	Touch      bool
+	Wake       bool
+	Status     int
+	RetryAfter int
+	Body       string
+}
+
+// parseTrafficRules returns nil if the container doesn't ignore any request.
+func parseTrafficRules(container *docker.Container) (*TrafficRules, error) {
+	rules := &TrafficRules{Status: http.StatusServiceUnavailable, RetryAfter: 60}
+
+	var err error
+	if v := containerSetting(container, IgnorePathSetting); v != "" {
+		if rules.Path, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", IgnorePathSetting, v, err)
+		}
+	}
+	if v := containerSetting(container, IgnoreAgentSetting); v != "" {
+		if rules.Agent, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", IgnoreAgentSetting, v, err)
+		}
+	}
+	for _, cidr := range strings.Split(containerSetting(container, IgnoreCIDRSetting), ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", IgnoreCIDRSetting, cidr, err)
+		}
+		rules.Networks = append(rules.Networks, network)
+	}
+
+	if rules.Path == nil && rules.Agent == nil && len(rules.Networks) == 0 {
+		return nil, nil
+	}
+
+	for _, b := range []struct {
+		key   string
+		value *bool
+	}{
+		{IgnoreTouchSetting, &rules.Touch},
+		{IgnoreWakeSetting, &rules.Wake},
+	} {
+		if v := containerSetting(container, b.key); v != "" {
+			if *b.value, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid %s=%s, expected true or false", b.key, v)
+			}
+		}
+	}
+	for _, n := range []struct {
+		key   string
+		value *int
+	}{
+		{IgnoreStatusSetting, &rules.Status},
+		{IgnoreRetryAfterSetting, &rules.RetryAfter},
+	} {
+		if v := containerSetting(container, n.key); v != "" {
+			if *n.value, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid %s=%s, expected a number", n.key, v)
+			}
+		}
+	}
+	if rules.Status < 100 || rules.Status > 599 {
+		return nil, fmt.Errorf("invalid %s=%d", IgnoreStatusSetting, rules.Status)
+	}
+	rules.Body = containerSetting(container, IgnoreBodySetting)
+	return rules, nil
+}
+
+// matches tells whether the request is ignored, any rule matching is enough.
+func (rules *TrafficRules) matches(r *http.Request) bool {
+	if rules.Path != nil && rules.Path.MatchString(r.URL.Path) {
+		return true
+	}
+	if rules.Agent != nil && rules.Agent.MatchString(r.UserAgent()) {
+		return true
+	}
+	if len(rules.Networks) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range rules.Networks {
+				if network.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// respond answers an ignored request instead of waking up the container.
+func (rules *TrafficRules) respond(w http.ResponseWriter) {
+	if rules.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(rules.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(rules.Status)
+	fmt.Fprint(w, rules.Body)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AwakeSetting declares windows during which the container is kept awake, as a
+// cron expression followed by /length and an optional time zone, e.g.
+// autosleep.awake="0 8 * * 1-5/10h Europe/Berlin". Several windows are
+// separated by semicolons.
+const AwakeSetting = "awake"
+
+// MaxWindow bounds the length of an awake window.
+const MaxWindow = 7 * 24 * time.Hour
+
+type cronField struct {
+	bits uint64
+	star bool
+}
+
+func (f cronField) has(v int) bool {
+	return f.bits&(1<<uint(v)) != 0
+}
+
+type Schedule struct {
+	minute, hour, dom, month, dow cronField
+	Duration                      time.Duration
+	Location                      *time.Location
+	Spec                          string
+}
+
+func parseSchedules(spec string) ([]*Schedule, error) {
+	schedules := []*Schedule{}
+	for _, s := range strings.Split(spec, ";") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		schedule, err := parseSchedule(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", AwakeSetting, s, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func parseSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 && len(fields) != 6 {
+		return nil, fmt.Errorf("expected \"min hour dom month dow/duration [time zone]\"")
+	}
+
+	s := &Schedule{Location: time.Local, Spec: spec}
+	if len(fields) == 6 {
+		loc, err := time.LoadLocation(fields[5])
+		if err != nil {
+			return nil, err
+		}
+		s.Location = loc
+	}
+
+	i := strings.LastIndex(fields[4], "/")
+	if i < 0 {
+		return nil, fmt.Errorf("missing the window's length, e.g. %s/8h", fields[4])
+	}
+	d, err := time.ParseDuration(fields[4][i+1:])
+	if err != nil || d <= 0 || d > MaxWindow {
+		return nil, fmt.Errorf("invalid window length %s", fields[4][i+1:])
+	}
+	s.Duration = d
+	fields[4] = fields[4][:i]
+
+	for _, f := range []struct {
+		field    *cronField
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	} {
+		var err error
+		if *f.field, err = parseCronField(fields[0], f.min, f.max); err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	}
+	// both 0 and 7 are sunday
+	if s.dow.has(7) {
+		s.dow.bits |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a list of *, a, a-b with an optional /step.
+func parseCronField(field string, min, max int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return f, fmt.Errorf("invalid step in %s", field)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+			f.star = f.star || step == 1
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return f, fmt.Errorf("invalid range in %s", field)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return f, fmt.Errorf("invalid value in %s", field)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return f, fmt.Errorf("%s out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f.bits |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func (s *Schedule) matches(t time.Time) bool {
+	if !s.minute.has(t.Minute()) || !s.hour.has(t.Hour()) || !s.month.has(int(t.Month())) {
+		return false
+	}
+	// like cron, when both days are restricted either one matching is enough
+	if !s.dom.star && !s.dow.star {
+		return s.dom.has(t.Day()) || s.dow.has(int(t.Weekday()))
+	}
+	return s.dom.has(t.Day()) && s.dow.has(int(t.Weekday()))
+}
+
+// windowStart returns when the window t falls in started, false if t is outside of the windows.
+func (s *Schedule) windowStart(t time.Time) (time.Time, bool) {
+	t = t.In(s.Location)
+	m := t.Truncate(time.Minute)
+	for t.Sub(m) < s.Duration {
+		if s.matches(m) {
+			return m, true
+		}
+		m = m.Add(-time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// awakeWindow returns the start of the awake window the container is in, if any.
+func (c *ContainerInfo) awakeWindow(t time.Time) (time.Time, bool) {
+	for _, s := range c.Schedules {
+		if start, ok := s.windowStart(t); ok {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// watchSchedules wakes up containers when their awake windows start.
+func watchSchedules() {
+	for {
+		now := time.Now()
+		for _, c := range containerInfos() {
+			start, ok := c.awakeWindow(now)
+			if !ok {
+				continue
+			}
+			// only once per window, if it's put to sleep by hand it stays asleep
+			c.mu.Lock()
+			seen := start.Equal(c.WindowWake)
+			c.WindowWake = start
+			c.mu.Unlock()
+			if !seen && !c.ready() {
+				log.Println("waking up container for its awake window: ", c.ID[:12], c.Name)
+				go wakeContainer(c)
+			}
+		}
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(time.Now()))
+	}
+}
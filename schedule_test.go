@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		star     bool
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}, true},
+		{"*/2", 0, 6, []int{0, 2, 4, 6}, false},
+		{"1-5", 0, 7, []int{1, 2, 3, 4, 5}, false},
+		{"1-5/2", 0, 7, []int{1, 3, 5}, false},
+		{"5/15", 0, 59, []int{5, 20, 35, 50}, false}, // a single value with a step runs to the max
+		{"7", 0, 7, []int{7}, false},
+		{"1,3,5", 1, 31, []int{1, 3, 5}, false},
+		{"0,30-31", 0, 59, []int{0, 30, 31}, false},
+	}
+	for _, test := range tests {
+		f, err := parseCronField(test.field, test.min, test.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %s", test.field, err)
+			continue
+		}
+		got := []int{}
+		for v := test.min; v <= test.max; v++ {
+			if f.has(v) {
+				got = append(got, v)
+			}
+		}
+		if !equalInts(got, test.want) || f.star != test.star {
+			t.Errorf("parseCronField(%q) = %v star %t, want %v star %t", test.field, got, f.star, test.want, test.star)
+		}
+	}
+}
+
+func TestParseCronFieldErrors(t *testing.T) {
+	for _, field := range []string{"", "x", "8", "5-1", "1-x", "*/0", "*/x", "0-60"} {
+		if _, err := parseCronField(field, 0, 7); err == nil {
+			t.Errorf("parseCronField(%q) succeeded, want an error", field)
+		}
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	sunday := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	monday := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	thursdayFirst := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	tuesday := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		t    time.Time
+		want bool
+	}{
+		// both 0 and 7 are sunday
+		{"0 9 * * 0/1h UTC", sunday, true},
+		{"0 9 * * 7/1h UTC", sunday, true},
+		{"0 9 * * 7/1h UTC", monday, false},
+		{"0 9 * * 5-7/1h UTC", sunday, true},
+
+		// a single value with a step
+		{"5/15 9 * * */1h UTC", sunday.Add(20 * time.Minute), true},
+		{"5/15 9 * * */1h UTC", sunday, false},
+
+		// when both days are restricted either one matching is enough
+		{"0 9 1 * 1/1h UTC", thursdayFirst, true},
+		{"0 9 1 * 1/1h UTC", monday, true},
+		{"0 9 1 * 1/1h UTC", tuesday, false},
+
+		// when only one is restricted it has to match
+		{"0 9 1 * */1h UTC", thursdayFirst, true},
+		{"0 9 1 * */1h UTC", monday, false},
+		{"0 9 * * 1/1h UTC", thursdayFirst, false},
+		{"0 9 */2 * 1/1h UTC", monday, true}, // a stepped star restricts the day
+		{"0 9 */2 * 1/1h UTC", tuesday, false},
+	}
+	for _, test := range tests {
+		s, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %s", test.spec, err)
+			continue
+		}
+		if got := s.matches(test.t); got != test.want {
+			t.Errorf("%q matches %s = %t, want %t", test.spec, test.t.Format("Mon Jan 2 15:04"), got, test.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"0 9 * * 1-5",            // no window length
+		"0 9 * * 1-5/0h",         // empty window
+		"0 9 * * 1-5/200h",       // longer than MaxWindow
+		"0 9 * * 8/1h",           // no day 8
+		"60 9 * * */1h",          // no minute 60
+		"0 9 0 * */1h",           // no day of month 0
+		"0 9 * * */1h Nowhere/X", // unknown time zone
+		"0 9 * */1h",             // missing a field
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// StateFile keeps the idle timers, sleep reasons and traffic stats across
+// restarts of autosleep. Empty disables it.
+var StateFile string
+
+const (
+	DefaultStateFile  = "/var/lib/autosleep/state.json"
+	StateSaveInterval = time.Minute
+)
+
+// why a container was put to sleep
+const (
+	SleepReasonIdle  = "idle"
+	SleepReasonAdmin = "admin"
+)
+
+type containerState struct {
+	Name        string    `json:"name"`
+	LastAccess  time.Time `json:"last_access"`
+	SleepReason string    `json:"sleep_reason,omitempty"`
+	SleptAt     time.Time `json:"slept_at"`
+	WindowWake  time.Time `json:"window_wake"`
+	PrewarmSlot int64     `json:"prewarm_slot,omitempty"`
+	PrewarmedAt time.Time `json:"prewarmed_at"`
+}
+
+type savedState struct {
+	SavedAt     time.Time                   `json:"saved_at"`
+	Containers  map[string]*containerState  `json:"containers"`  // maps container ID to its state
+	Predictions map[string]*AccessHistogram `json:"predictions"` // maps host to its access histogram
+}
+
+// saveState writes the state to a temporary file renamed over path, so a
+// crash never leaves a truncated state behind.
+func saveState(path string) error {
+	state := savedState{
+		SavedAt:     time.Now(),
+		Containers:  make(map[string]*containerState),
+		Predictions: predictions,
+	}
+	for _, c := range containerInfos() {
+		c.mu.Lock()
+		state.Containers[c.ID] = &containerState{
+			Name:        containerName(c),
+			LastAccess:  c.LastAccess,
+			SleepReason: c.SleepReason,
+			SleptAt:     c.SleptAt,
+			WindowWake:  c.WindowWake,
+			PrewarmSlot: c.PrewarmSlot,
+			PrewarmedAt: c.PrewarmedAt,
+		}
+		c.mu.Unlock()
+	}
+
+	predictionsMu.Lock()
+	b, err := json.Marshal(state)
+	predictionsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restoreState reloads the saved state at boot and reconciles it with the
+// containers just discovered. Containers that are gone are dropped, those
+// started while autosleep was down count as accessed when they started, and
+// sleep reasons only stick to containers that are still asleep.
+func restoreState(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, c := range containerInfos() {
+		s, ok := state.Containers[c.ID]
+		if !ok {
+			continue
+		}
+		restored++
+
+		awake := c.awake()
+		c.mu.Lock()
+		c.LastAccess = s.LastAccess
+		if awake && c.StartedAt.After(c.LastAccess) {
+			c.LastAccess = c.StartedAt
+		}
+		if !awake {
+			c.SleepReason = s.SleepReason
+			c.SleptAt = s.SleptAt
+		} else {
+			c.PrewarmedAt = s.PrewarmedAt
+		}
+		c.WindowWake = s.WindowWake
+		c.PrewarmSlot = s.PrewarmSlot
+		c.mu.Unlock()
+	}
+
+	predictionsMu.Lock()
+	for host, h := range state.Predictions {
+		if len(h.Hits) == SlotsPerWeek && len(h.Last) == SlotsPerWeek {
+			predictions[host] = h
+		}
+	}
+	predictionsMu.Unlock()
+
+	log.Printf("restored the state of %d containers saved at %s", restored, state.SavedAt)
+	return nil
+}
+
+// persistState saves the state every StateSaveInterval, and once more when
+// autosleep is stopped.
+func persistState(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(StateSaveInterval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := saveState(path); err != nil {
+				log.Errorf("unable to save the state to %s: %s", path, err)
+			}
+		case sig := <-signals:
+			if err := saveState(path); err != nil {
+				log.Errorf("unable to save the state to %s: %s", path, err)
+			}
+			log.Printf("received %s, exiting", sig)
+			os.Exit(0)
+		}
+	}
+}
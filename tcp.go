@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TCPLabel declares raw TCP listeners for the container, as [ip:]listen_port:container_port
+// separated by commas, e.g. autosleep.tcp=15432:5432
+const TCPLabel = "autosleep.tcp"
+
+type TCPRoute struct {
+	Listen string // address autosleep listens on, e.g. :15432
+	Port   string // container port
+}
+
+type tcpTarget struct {
+	containerInfo *ContainerInfo
+	port          string
+}
+
+var (
+	tcpMu        sync.Mutex
+	tcpTargets   = make(map[string]*tcpTarget)   // maps listen address to the container behind it
+	tcpListeners = make(map[string]net.Listener) // maps listen address to its listener
+)
+
+func parseTCPRoutes(labels map[string]string) ([]TCPRoute, error) {
+	routes := []TCPRoute{}
+	for _, spec := range strings.Split(labels[TCPLabel], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid %s=%s, expected [ip:]listen_port:container_port", TCPLabel, spec)
+		}
+		listen, port := spec[:i], spec[i+1:]
+		if !strings.Contains(listen, ":") {
+			listen = ":" + listen
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid %s=%s, bad container port %s", TCPLabel, spec, port)
+		}
+		if _, p, err := net.SplitHostPort(listen); err != nil {
+			return nil, fmt.Errorf("invalid %s=%s: %s", TCPLabel, spec, err)
+		} else if _, err := strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid %s=%s, bad listen port %s", TCPLabel, spec, p)
+		}
+		routes = append(routes, TCPRoute{Listen: listen, Port: port})
+	}
+	return routes, nil
+}
+
+// updateTCPListeners starts listening for the TCP routes of the known containers
+// and stops listening on addresses no container claims anymore.
+func updateTCPListeners() {
+	targets := make(map[string]*tcpTarget)
+	for _, c := range containerInfos() {
+		for _, route := range c.TCPRoutes {
+			if existing, ok := targets[route.Listen]; ok {
+				log.Warningf("container: %s has the same %s=%s as container: %s", c.ID[:12], TCPLabel, route.Listen, existing.containerInfo.ID[:12])
+				if !existing.containerInfo.startedAt().Before(c.startedAt()) {
+					continue
+				}
+			}
+			targets[route.Listen] = &tcpTarget{containerInfo: c, port: route.Port}
+		}
+	}
+
+	tcpMu.Lock()
+	defer tcpMu.Unlock()
+
+	tcpTargets = targets
+	for addr, l := range tcpListeners {
+		if _, ok := targets[addr]; !ok {
+			log.Println("stopped listening on: ", addr)
+			l.Close()
+			delete(tcpListeners, addr)
+		}
+	}
+	for addr, target := range targets {
+		if _, ok := tcpListeners[addr]; ok {
+			continue
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Errorln("Error listening for container: ", target.containerInfo.ID[:12], target.containerInfo.Name, err)
+			continue
+		}
+		log.Println("listening on: ", addr, "for container: ", target.containerInfo.ID[:12], target.containerInfo.Name)
+		tcpListeners[addr] = l
+		go acceptTCP(addr, l)
+	}
+}
+
+func acceptTCP(addr string, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			// closed by updateTCPListeners
+			return
+		}
+		go serveTCP(addr, conn)
+	}
+}
+
+// serveTCP wakes the container on the first connection, waits for its port and
+// then copies bytes both ways. The container stays awake while connections are open.
+func serveTCP(addr string, conn net.Conn) {
+	defer conn.Close()
+
+	tcpMu.Lock()
+	target := tcpTargets[addr]
+	tcpMu.Unlock()
+	if target == nil {
+		return
+	}
+
+	containerInfo := target.containerInfo
+	connOpened(containerInfo)
+	defer connClosed(containerInfo)
+	recordAccess(containerInfo, time.Now())
+
+	if err := wakeContainer(containerInfo); err != nil {
+		return
+	}
+
+	containerInfo.mu.Lock()
+	ip := containerInfo.IP
+	containerInfo.mu.Unlock()
+	if ip == "" {
+		ip = "127.0.0.1"
+	}
+	backendAddr := net.JoinHostPort(ip, target.port)
+
+	timeout := ProbeTimeout * time.Second
+	if containerInfo.Probe != nil {
+		timeout = containerInfo.Probe.Timeout
+	}
+
+	var backend net.Conn
+	err := retry(timeout, func() error {
+		var err error
+		backend, err = net.DialTimeout("tcp", backendAddr, ProbeCheckTimeout)
+		return err
+	})
+	if err != nil {
+		log.Errorln("Error connecting to container: ", containerInfo.ID[:12], containerInfo.Name, backendAddr, err)
+		return
+	}
+	defer backend.Close()
+
+	splice(conn, conn, backend)
+}
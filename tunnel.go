@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const TunnelDialTimeout = 10 * time.Second
+
+// isUpgrade tells whether the request asks to switch protocols, e.g. to websockets.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tunnel hands the upgrade request over to the upstream and then copies bytes
+// both ways until either side hangs up. The open tunnel keeps the container
+// awake until it's closed.
+func tunnel(w http.ResponseWriter, r *http.Request, u *url.URL, containerInfo *ContainerInfo) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "autosleep: upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backend, err := net.DialTimeout("tcp", u.Host, TunnelDialTimeout)
+	if err != nil {
+		log.Errorln("Error connecting to upstream: ", u.Host, err)
+		http.Error(w, "autosleep: upstream unreachable", http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+	if err := r.Write(backend); err != nil {
+		log.Errorln("Error writing upgrade request to upstream: ", u.Host, err)
+		http.Error(w, "autosleep: upstream unreachable", http.StatusBadGateway)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Errorln("Error hijacking connection: ", err)
+		return
+	}
+	defer conn.Close()
+
+	// the server's read/write timeouts would kill long lived connections
+	conn.SetDeadline(time.Time{})
+
+	if containerInfo != nil {
+		connOpened(containerInfo)
+		defer connClosed(containerInfo)
+	}
+
+	// brw holds whatever the client sent after the request headers
+	splice(conn, brw, backend)
+}
+
+// splice copies bytes between the client and the backend until either side
+// hangs up, reading the client's side from r.
+func splice(conn net.Conn, r io.Reader, backend net.Conn) {
+	errc := make(chan error, 2)
+	cp := func(dst io.Writer, src io.Reader) {
+		_, err := io.Copy(dst, src)
+		errc <- err
+	}
+	go cp(backend, r)
+	go cp(conn, backend)
+
+	<-errc
+	// unblock the other direction
+	conn.Close()
+	backend.Close()
+	<-errc
+}
+
+// connOpened and connClosed track connections that are still open to the
+// container, upgraded HTTP or raw TCP. The container isn't put to sleep while it
+// has any.
+func connOpened(containerInfo *ContainerInfo) {
+	atomic.AddInt32(&containerInfo.OpenConns, 1)
+	containerInfo.touch(time.Now())
+}
+
+func connClosed(containerInfo *ContainerInfo) {
+	containerInfo.touch(time.Now())
+	atomic.AddInt32(&containerInfo.OpenConns, -1)
+}
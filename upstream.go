@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/girishso/autosleep/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// DirectLabel overrides the -direct flag for a single container, e.g.
+// autosleep.direct=true proxies straight to the container even if nginx is the default.
+const DirectLabel = "autosleep.direct"
+
+// UpstreamSetting overrides -upstream for the container, e.g.
+// autosleep.upstream=http://10.0.0.5:8080
+const UpstreamSetting = "upstream"
+
+// isDirect tells whether requests for the container skip nginx.
+func isDirect(container *docker.Container) bool {
+	v, ok := container.Config.Labels[DirectLabel]
+	if !ok {
+		return config().Direct
+	}
+	direct, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warningf("container: %s %s, invalid %s=%s, using -direct=%t", container.ID[:12], container.Name, DirectLabel, v, config().Direct)
+		return config().Direct
+	}
+	return direct
+}
+
+func parseUpstream(container *docker.Container) (*url.URL, error) {
+	v := containerSetting(container, UpstreamSetting)
+	if v == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s=%s, expected a url like http://127.0.0.1:8080", UpstreamSetting, v)
+	}
+	return u, nil
+}
+
+// containerPort picks the port the app listens on the same way nginx-proxy
+// does: the port in VIRTUAL_PORT, else the only exposed port, else port 80.
+// Direct proxying and the readiness probe both use it so they agree.
+func containerPort(container *docker.Container) (string, error) {
+	if port := splitKeyValueSlice(container.Config.Env)["VIRTUAL_PORT"]; port != "" {
+		return port, nil
+	}
+	if len(container.NetworkSettings.Ports) == 1 {
+		for k := range container.NetworkSettings.Ports {
+			if k.Proto() != "tcp" {
+				return "", fmt.Errorf("port %s/%s is not a tcp port", k.Port(), k.Proto())
+			}
+			return k.Port(), nil
+		}
+	}
+	return "80", nil
+}
+
+// containerTarget returns the container's address on its containerPort.
+func containerTarget(container *docker.Container) (*url.URL, error) {
+	port, err := containerPort(container)
+	if err != nil {
+		return nil, err
+	}
+	ip := container.NetworkSettings.IPAddress
+	if ip == "" {
+		// host networking, the app listens on the host itself
+		ip = "127.0.0.1"
+	}
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(ip, port)}, nil
+}
+
+// updateTarget refreshes the container's addresses, it has to be called
+// every time the container starts since docker may hand out a different IP.
+func updateTarget(containerInfo *ContainerInfo, container *docker.Container) {
+	var target *url.URL
+	if containerInfo.Direct {
+		var err error
+		if target, err = containerTarget(container); err != nil {
+			log.Errorln("Error finding upstream address for container: ", containerInfo.ID[:12], containerInfo.Name, err)
+		}
+	}
+
+	containerInfo.mu.Lock()
+	defer containerInfo.mu.Unlock()
+	containerInfo.IP = container.NetworkSettings.IPAddress
+	if containerInfo.Direct {
+		containerInfo.Target = target
+	}
+}
+
+// upstreamURL returns where the requests for the container should be forwarded to.
+func upstreamURL(containerInfo *ContainerInfo) (*url.URL, error) {
+	if containerInfo == nil {
+		return config().upstream, nil
+	}
+	if !containerInfo.Direct {
+		if containerInfo.Upstream != nil {
+			return containerInfo.Upstream, nil
+		}
+		return config().upstream, nil
+	}
+	containerInfo.mu.Lock()
+	target := containerInfo.Target
+	containerInfo.mu.Unlock()
+	if target == nil {
+		return nil, fmt.Errorf("no upstream address for container %s %s", containerInfo.ID[:12], containerInfo.Name)
+	}
+	return target, nil
+}
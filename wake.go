@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// wake is a wake up in progress, it's shared by every request waiting on the
+// same container.
+type wake struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	wakesMu sync.Mutex
+	wakes   = make(map[string]*wake) // maps container ID to the wake in progress
+)
+
+// wakeContainer wakes up the container together with the rest of its group
+// and their dependencies, one at a time in dependency order, waiting for each
+// to be ready before moving on.
+func wakeContainer(containerInfo *ContainerInfo) error {
+	members := containerInfo.members()
+	for _, m := range members {
+		if m.DependsError != nil {
+			containerInfo.setWakeError(m.DependsError)
+			return m.DependsError
+		}
+	}
+
+	for _, m := range members {
+		if err := wakeOne(m); err != nil {
+			if m != containerInfo {
+				err = fmt.Errorf("container %s: %s", containerName(m), err)
+			}
+			containerInfo.setWakeError(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// wakeOne makes sure the container is running and ready. Concurrent callers
+// for the same container share a single wake up and all get its result, a
+// caller arriving mid-wake waits for the wake in progress instead of starting
+// another one.
+func wakeOne(containerInfo *ContainerInfo) error {
+	wakesMu.Lock()
+	w, ok := wakes[containerInfo.ID]
+	if !ok {
+		if containerInfo.awake() {
+			wakesMu.Unlock()
+			return nil
+		}
+
+		w = &wake{done: make(chan struct{})}
+		wakes[containerInfo.ID] = w
+
+		// the wake up runs on its own so it completes even if the request that
+		// triggered it goes away
+		go func() {
+			log.Warnf("waking up container: %s %s, sleep mode: %s", containerInfo.ID[:12], containerInfo.Name, containerInfo.SleepMode)
+			w.err = startContainer(containerInfo)
+			countWake(containerInfo, w.err)
+			if w.err != nil {
+				log.Errorln("Error starting container: ", containerInfo.ID[:12], containerInfo.Name, w.err)
+			}
+			containerInfo.setWakeError(w.err)
+
+			wakesMu.Lock()
+			delete(wakes, containerInfo.ID)
+			wakesMu.Unlock()
+			close(w.done)
+		}()
+	}
+	wakesMu.Unlock()
+
+	<-w.done
+	return w.err
+}
+
+// waking tells whether a wake up is in progress for the container.
+func waking(containerInfo *ContainerInfo) bool {
+	wakesMu.Lock()
+	defer wakesMu.Unlock()
+	_, ok := wakes[containerInfo.ID]
+	return ok
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// WakingPageLabel points to a template on the autosleep host that replaces the
+// default "waking up" page for the container.
+const WakingPageLabel = "autosleep.waking_page"
+
+// StatusPath is served by autosleep itself on every managed host, the waking up
+// page polls it to know when to reload.
+const StatusPath = "/__autosleep/status"
+
+const defaultWakingPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Host}} is waking up</title>
+<noscript><meta http-equiv="refresh" content="5"></noscript>
+<style>
+body { font-family: sans-serif; color: #444; text-align: center; margin-top: 15%; }
+#error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Host}} is waking up</h1>
+<p id="message">This app was sleeping, it will be ready in a few seconds.</p>
+<p id="error"></p>
+<script>
+(function poll() {
+	var xhr = new XMLHttpRequest();
+	xhr.open("GET", {{.StatusURL}} + "?" + Date.now());
+	xhr.onload = function() {
+		var status = {};
+		try { status = JSON.parse(xhr.responseText); } catch (e) {}
+		if (status.ready) {
+			window.location.reload();
+		} else if (status.error) {
+			document.getElementById("error").textContent = status.error;
+		} else {
+			setTimeout(poll, 1000);
+		}
+	};
+	xhr.onerror = function() { setTimeout(poll, 1000); };
+	xhr.send();
+})();
+</script>
+</body>
+</html>
+`
+
+var wakingPage = template.Must(template.New("waking").Parse(defaultWakingPage))
+
+type wakingPageData struct {
+	Host      string
+	Name      string
+	StatusURL string
+}
+
+type wakeStatus struct {
+	Host   string `json:"host"`
+	Ready  bool   `json:"ready"`
+	Waking bool   `json:"waking"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseWakingPage loads the container's own waking up page, nil means the default one.
+func parseWakingPage(labels map[string]string) (*template.Template, error) {
+	path := labels[WakingPageLabel]
+	if path == "" {
+		return nil, nil
+	}
+	return template.ParseFiles(path)
+}
+
+// wantsWakingPage tells whether the request comes from a browser, API clients
+// keep waiting for the container instead.
+func wantsWakingPage(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func serveWakingPage(w http.ResponseWriter, r *http.Request, containerInfo *ContainerInfo) {
+	t := containerInfo.WakingPage
+	if t == nil {
+		t = wakingPage
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	data := wakingPageData{Host: r.Host, Name: containerInfo.Name, StatusURL: StatusPath}
+	if err := t.Execute(w, data); err != nil {
+		log.Errorln("Error rendering waking up page: ", containerInfo.ID[:12], containerInfo.Name, err)
+	}
+}
+
+func serveStatus(w http.ResponseWriter, r *http.Request, containerInfo *ContainerInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if containerInfo == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(wakeStatus{Host: r.Host, Error: "unknown host"})
+		return
+	}
+
+	status := wakeStatus{Host: r.Host, Waking: containerInfo.groupWaking()}
+	status.Ready = containerInfo.ready()
+	if err := containerInfo.wakeError(); !status.Ready && !status.Waking && err != nil {
+		status.Error = err.Error()
+	}
+	json.NewEncoder(w).Encode(status)
+}